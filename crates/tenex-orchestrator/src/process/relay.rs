@@ -169,9 +169,12 @@ impl RelayManager {
 
         let config_path = relay_dir.join("relay.json");
         let config = serde_json::json!({
+            "config_version": 1,
             "port": port,
             "bind_address": "127.0.0.1",
             "data_dir": data_dir.to_string_lossy(),
+            // Finish before stop() gives up and sends SIGKILL.
+            "shutdown_timeout_seconds": GRACEFUL_SHUTDOWN_SECS - 1,
             "nip11": {
                 "name": "TENEX Local Relay",
                 "description": "Local Nostr relay for TENEX",
@@ -214,6 +217,8 @@ impl RelayManager {
 
     async fn wait_for_readiness(&self, port: u16) -> bool {
         for _ in 0..READINESS_ATTEMPTS {
+            // Liveness, not /ready: a relay in maintenance mode or short of
+            // sync peers is degraded, not failed, and must not be killed.
             if self.check_health(port).await {
                 return true;
             }
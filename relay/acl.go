@@ -3,9 +3,10 @@ package main
 import (
 	"bufio"
 	"context"
-	"log"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -33,13 +34,30 @@ type deferredSub struct {
 type ACL struct {
 	adminPubkeys map[string]bool
 	whitelist    map[string]bool
+	grants       map[string]aclGrant // why each whitelist entry was added
 	fileAllow    map[string]bool
 	deferred     map[string][]deferredSub // pubkey -> pending subs awaiting whitelist
 	mu           sync.RWMutex
 
 	storage eventstore.Store
+	// searchEvents answers NIP-50 filters when search is enabled; the
+	// store would ignore their search term.
+	searchEvents func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error)
 
 	whitelistFilePath string
+
+	// initErr is set if the whitelist couldn't be built from storage.
+	initErr error
+
+	// wg tracks backfills and the whitelist file sync, so shutdown can
+	// wait for them before the store closes.
+	wg sync.WaitGroup
+}
+
+// aclGrant records the 14199 that first whitelisted a pubkey.
+type aclGrant struct {
+	GrantedBy string
+	EventID   string
 }
 
 func NewACL(adminPubkeys []string, storage eventstore.Store) *ACL {
@@ -51,6 +69,7 @@ func NewACL(adminPubkeys []string, storage eventstore.Store) *ACL {
 	acl := &ACL{
 		adminPubkeys:      admins,
 		whitelist:         make(map[string]bool),
+		grants:            make(map[string]aclGrant),
 		fileAllow:         make(map[string]bool),
 		deferred:          make(map[string][]deferredSub),
 		storage:           storage,
@@ -62,6 +81,14 @@ func NewACL(adminPubkeys []string, storage eventstore.Store) *ACL {
 	return acl
 }
 
+// Ready reports whether the whitelist was built from storage at startup.
+func (a *ACL) Ready() error {
+	if a.initErr != nil {
+		return fmt.Errorf("whitelist was not built from storage: %w", a.initErr)
+	}
+	return nil
+}
+
 func (a *ACL) IsWhitelisted(pubkey string) bool {
 	if pubkey == "" {
 		return false
@@ -71,6 +98,59 @@ func (a *ACL) IsWhitelisted(pubkey string) bool {
 	return a.adminPubkeys[pubkey] || a.whitelist[pubkey] || a.fileAllow[pubkey]
 }
 
+// IsAdmin reports whether pubkey is one of the configured admin pubkeys.
+func (a *ACL) IsAdmin(pubkey string) bool {
+	if pubkey == "" {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.adminPubkeys[pubkey]
+}
+
+// SetAdmins replaces the admin pubkeys, e.g. on config reload.
+func (a *ACL) SetAdmins(adminPubkeys []string) {
+	admins := make(map[string]bool, len(adminPubkeys))
+	for _, pk := range adminPubkeys {
+		admins[pk] = true
+	}
+
+	a.mu.Lock()
+	removed := false
+	for pk := range a.adminPubkeys {
+		if !admins[pk] {
+			removed = true
+		}
+	}
+	a.adminPubkeys = admins
+	toBackfill := make(map[string][]deferredSub)
+	for pk := range admins {
+		if subs := a.deferred[pk]; len(subs) > 0 {
+			toBackfill[pk] = subs
+			delete(a.deferred, pk)
+		}
+	}
+	a.mu.Unlock()
+
+	logACL.Info("admin pubkeys updated", "admins", len(admins))
+	for pk, subs := range toBackfill {
+		a.goBackfill(pk, subs)
+	}
+
+	// Admins are never added to the dynamic whitelist, so a former admin
+	// regains any entry their stored 14199s grant them.
+	if removed {
+		ch, err := a.storage.QueryEvents(context.Background(), nostr.Filter{Kinds: []int{14199}})
+		if err != nil {
+			logACL.Error("failed to query stored 14199 events", "err", err)
+			return
+		}
+		for evt := range ch {
+			a.ProcessWhitelistEvent(evt)
+		}
+	}
+}
+
 func defaultDaemonWhitelistPath() string {
 	if base := os.Getenv("TENEX_BASE_DIR"); base != "" {
 		return filepath.Join(base, "daemon", "whitelist.txt")
@@ -85,7 +165,9 @@ func (a *ACL) StartWhitelistFileSync(ctx context.Context) {
 	a.loadWhitelistFile()
 
 	ticker := time.NewTicker(2 * time.Second)
+	a.wg.Add(1)
 	go func() {
+		defer a.wg.Done()
 		defer ticker.Stop()
 		for {
 			select {
@@ -110,7 +192,7 @@ func (a *ACL) loadWhitelistFile() {
 	if err != nil {
 		// Missing file means no daemon whitelist entries.
 		if !os.IsNotExist(err) {
-			log.Printf("[acl] failed to open whitelist file %s: %v", path, err)
+			logACL.Warn("failed to open whitelist file", "path", path, "err", err)
 		}
 	} else {
 		defer file.Close()
@@ -127,13 +209,13 @@ func (a *ACL) loadWhitelistFile() {
 				continue
 			}
 			if !nostr.IsValidPublicKey(line) {
-				log.Printf("[acl] ignoring invalid pubkey in %s:%d", path, lineNo)
+				logACL.Warn("ignoring invalid pubkey in whitelist file", "path", path, "line", lineNo)
 				continue
 			}
 			fileAllow[line] = true
 		}
 		if err := scanner.Err(); err != nil {
-			log.Printf("[acl] failed reading whitelist file %s: %v", path, err)
+			logACL.Warn("failed to read whitelist file", "path", path, "err", err)
 		}
 	}
 
@@ -144,7 +226,7 @@ func (a *ACL) loadWhitelistFile() {
 	a.mu.Unlock()
 
 	if changed {
-		log.Printf("[acl] loaded %d pubkey(s) from whitelist file %s", len(fileAllow), path)
+		logACL.Info("loaded whitelist file", "path", path, "pubkeys", len(fileAllow))
 	}
 }
 
@@ -168,29 +250,30 @@ func (a *ACL) buildWhitelistFromStorage() {
 		Kinds: []int{14199},
 	})
 	if err != nil {
-		log.Printf("[acl] failed to query stored 14199 events: %v", err)
+		logACL.Error("failed to query stored 14199 events", "err", err)
+		a.initErr = err
 		return
 	}
 
 	for evt := range ch {
 		// Whitelist the 14199 author themselves
 		if !a.adminPubkeys[evt.PubKey] && !a.whitelist[evt.PubKey] {
-			a.whitelist[evt.PubKey] = true
-			log.Printf("[acl] whitelisted author %s... (published 14199)", truncatePubkey(evt.PubKey))
+			a.grant(evt.PubKey, evt)
+			logACL.Debug("whitelisted author of stored 14199", "pubkey", evt.PubKey, "event_id", evt.ID)
 		}
 
 		for _, tag := range evt.Tags {
 			if len(tag) >= 2 && tag[0] == "p" {
 				pk := tag[1]
 				if !a.adminPubkeys[pk] && !a.whitelist[pk] {
-					a.whitelist[pk] = true
-					log.Printf("[acl] whitelisted %s... (from stored 14199 by %s...)", truncatePubkey(pk), truncatePubkey(evt.PubKey))
+					a.grant(pk, evt)
+					logACL.Debug("whitelisted from stored 14199", "pubkey", pk, "granted_by", evt.PubKey, "event_id", evt.ID)
 				}
 			}
 		}
 	}
 
-	log.Printf("[acl] built whitelist: %d admin(s), %d dynamic entries", len(a.adminPubkeys), len(a.whitelist))
+	logACL.Info("built whitelist", "admins", len(a.adminPubkeys), "dynamic", len(a.whitelist))
 }
 
 // ProcessWhitelistEvent handles a kind 14199 event.  A 14199 is
@@ -207,18 +290,18 @@ func (a *ACL) ProcessWhitelistEvent(event *nostr.Event) {
 	var newlyWhitelisted []string
 
 	if !a.adminPubkeys[event.PubKey] && !a.whitelist[event.PubKey] {
-		a.whitelist[event.PubKey] = true
+		a.grant(event.PubKey, event)
 		newlyWhitelisted = append(newlyWhitelisted, event.PubKey)
-		log.Printf("[acl] whitelisted author %s... (published 14199)", truncatePubkey(event.PubKey))
+		logACL.Info("whitelisted author of 14199", "pubkey", event.PubKey, "event_id", event.ID)
 	}
 
 	for _, tag := range event.Tags {
 		if len(tag) >= 2 && tag[0] == "p" {
 			pk := tag[1]
 			if !a.adminPubkeys[pk] && !a.whitelist[pk] {
-				a.whitelist[pk] = true
+				a.grant(pk, event)
 				newlyWhitelisted = append(newlyWhitelisted, pk)
-				log.Printf("[acl] whitelisted %s... (14199 from %s...)", truncatePubkey(pk), truncatePubkey(event.PubKey))
+				logACL.Info("whitelisted from 14199", "pubkey", pk, "granted_by", event.PubKey, "event_id", event.ID)
 			}
 		}
 	}
@@ -235,18 +318,89 @@ func (a *ACL) ProcessWhitelistEvent(event *nostr.Event) {
 	a.mu.Unlock()
 
 	for pk, subs := range toBackfill {
-		go a.backfillSubs(pk, subs)
+		a.goBackfill(pk, subs)
 	}
 }
 
+// grant must be called with a.mu held, or before the ACL is shared.
+func (a *ACL) grant(pubkey string, event *nostr.Event) {
+	a.whitelist[pubkey] = true
+	a.grants[pubkey] = aclGrant{GrantedBy: event.PubKey, EventID: event.ID}
+}
+
+// aclEntry is a whitelisted pubkey and every reason it is whitelisted.
+type aclEntry struct {
+	Pubkey string `json:"pubkey"`
+	// Sources has "admin" for admin_pubkeys, "whitelist_file" for the
+	// daemon's whitelist.txt and "14199" for a whitelist event.
+	Sources   []string `json:"sources"`
+	GrantedBy string   `json:"granted_by,omitempty"`
+	EventID   string   `json:"event_id,omitempty"`
+}
+
+// Entries lists everyone the ACL lets read, sorted by pubkey.
+func (a *ACL) Entries() []aclEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	entries := make(map[string]*aclEntry)
+	add := func(pubkey, source string) *aclEntry {
+		e := entries[pubkey]
+		if e == nil {
+			e = &aclEntry{Pubkey: pubkey}
+			entries[pubkey] = e
+		}
+		e.Sources = append(e.Sources, source)
+		return e
+	}
+	for pk := range a.adminPubkeys {
+		add(pk, "admin")
+	}
+	for pk := range a.fileAllow {
+		add(pk, "whitelist_file")
+	}
+	for pk := range a.whitelist {
+		e := add(pk, "14199")
+		e.GrantedBy, e.EventID = a.grants[pk].GrantedBy, a.grants[pk].EventID
+	}
+
+	list := make([]aclEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Pubkey < list[j].Pubkey })
+	return list
+}
+
+func (a *ACL) goBackfill(pubkey string, subs []deferredSub) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.backfillSubs(pubkey, subs)
+	}()
+}
+
+// Wait blocks until backfills and the whitelist file sync have returned.
+// Cancel the whitelist sync's context first.
+func (a *ACL) Wait() {
+	a.wg.Wait()
+}
+
 func (a *ACL) backfillSubs(pubkey string, subs []deferredSub) {
 	for _, sub := range subs {
 		if sub.ctx.Err() != nil {
 			continue // subscription already closed or connection dropped
 		}
-		ch, err := a.storage.QueryEvents(sub.ctx, sub.filter)
+		query := a.storage.QueryEvents
+		if sub.filter.Search != "" {
+			if a.searchEvents == nil {
+				continue // RejectFilter turns searches away without an index
+			}
+			query = a.searchEvents
+		}
+		ch, err := query(sub.ctx, sub.filter)
 		if err != nil {
-			log.Printf("[acl] backfill query failed for %s...: %v", truncatePubkey(pubkey), err)
+			logACL.Warn("backfill query failed", "pubkey", pubkey, "sub_id", sub.id, "err", err)
 			continue
 		}
 		count := 0
@@ -261,7 +415,7 @@ func (a *ACL) backfillSubs(pubkey string, subs []deferredSub) {
 			sub.ws.WriteJSON(nostr.EventEnvelope{SubscriptionID: &sub.id, Event: *event})
 			count++
 		}
-		log.Printf("[acl] backfilled %d event(s) to %s... (sub %s)", count, truncatePubkey(pubkey), sub.id)
+		logACL.Info("backfilled deferred subscription", "pubkey", pubkey, "sub_id", sub.id, "events", count)
 	}
 }
 
@@ -331,7 +485,7 @@ func (a *ACL) OverwriteFilterHook(ctx context.Context, filter *nostr.Filter) {
 	a.mu.Unlock()
 
 	filter.LimitZero = true
-	log.Printf("[acl] deferred subscription for non-whitelisted pubkey %s...", truncatePubkey(pubkey))
+	logACL.Debug("deferred subscription for non-whitelisted pubkey", "pubkey", pubkey, "sub_id", subID, "ip", khatru.GetIP(ctx))
 }
 
 // PreventBroadcastHook blocks live event delivery to non-whitelisted
@@ -359,10 +513,3 @@ func (a *ACL) OnEventSavedHook(ctx context.Context, event *nostr.Event) {
 	}
 	a.ProcessWhitelistEvent(event)
 }
-
-func truncatePubkey(s string) string {
-	if len(s) > 12 {
-		return s[:12]
-	}
-	return s
-}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Admin endpoints accept either of two credentials:
+//
+//   - The local admin token. The relay writes a fresh random token to
+//     <data_dir>/admin.token on every start; tooling running as the same user
+//     (for example `tenex-relay export` while the relay holds the storage
+//     lock) reads it and sends it as a bearer token.
+//   - A NIP-98 HTTP auth event signed by one of the configured admin pubkeys.
+
+func adminTokenPath(dataDir string) string {
+	return filepath.Join(dataDir, "admin.token")
+}
+
+func writeAdminToken(dataDir string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(adminTokenPath(dataDir), []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func readAdminToken(dataDir string) (string, error) {
+	data, err := os.ReadFile(adminTokenPath(dataDir))
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("admin token file is empty")
+	}
+	return token, nil
+}
+
+// requireAdmin rejects requests that don't carry admin credentials.
+func (r *Relay) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !r.isAdminRequest(req) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func (r *Relay) isAdminRequest(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+
+	if presented, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		r.mu.RLock()
+		token := r.adminToken
+		r.mu.RUnlock()
+		return token != "" && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(presented)), []byte(token)) == 1
+	}
+
+	if strings.HasPrefix(auth, "Nostr ") {
+		pubkey, err := nip98Pubkey(req)
+		if err != nil {
+			logRelay.Warn("rejected admin request", "ip", khatru.GetIPFromRequest(req), "path", req.URL.Path, "err", err)
+			return false
+		}
+		return r.acl.IsAdmin(pubkey)
+	}
+
+	return false
+}
+
+// nip98Pubkey validates a NIP-98 HTTP auth header for req and returns the
+// signing pubkey.
+func nip98Pubkey(req *http.Request) (string, error) {
+	encoded, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Nostr ")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", errors.New("invalid base64 auth event")
+	}
+
+	var evt nostr.Event
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		return "", errors.New("invalid auth event json")
+	}
+	if evt.Kind != nostr.KindHTTPAuth {
+		return "", fmt.Errorf("auth event has kind %d, expected %d", evt.Kind, nostr.KindHTTPAuth)
+	}
+	if !evt.CheckID() {
+		return "", errors.New("auth event id is computed incorrectly")
+	}
+	if ok, _ := evt.CheckSignature(); !ok {
+		return "", errors.New("auth event signature is invalid")
+	}
+
+	now := nostr.Now()
+	if evt.CreatedAt < now-60 || evt.CreatedAt > now+60 {
+		return "", errors.New("auth event is not recent")
+	}
+
+	if tag := evt.Tags.Find("method"); tag == nil || !strings.EqualFold(tag[1], req.Method) {
+		return "", errors.New("auth event method tag does not match")
+	}
+	if tag := evt.Tags.Find("u"); tag == nil || strings.TrimSuffix(tag[1], "/") != strings.TrimSuffix(requestURL(req), "/") {
+		return "", errors.New("auth event u tag does not match the request URL")
+	}
+
+	return evt.PubKey, nil
+}
+
+// requestURL reconstructs the absolute URL a client used to reach req,
+// honouring reverse proxy headers.
+func requestURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := req.Host
+	if fwd := req.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host = fwd
+	}
+	return scheme + "://" + host + req.URL.RequestURI()
+}
+
+// localRelayURL is the HTTP base URL local tooling uses to reach a running
+// relay on this machine.
+func localRelayURL(config *Config) string {
+	host := config.BindAddress
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	scheme := "http://"
+	if config.TLS.Enabled {
+		scheme = "https://"
+	}
+	return scheme + net.JoinHostPort(host, strconv.Itoa(config.Port))
+}
+
+// localHTTPClient returns a client for localRelayURL. With TLS on it trusts
+// exactly the certificate the relay serves, which may be self-signed.
+func localHTTPClient(config *Config, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if config.TLS.Enabled {
+		certFile, _ := config.TLS.files(config.DataDir)
+		client.Transport = &http.Transport{TLSClientConfig: pinnedTLSConfig(certFile)}
+	}
+	return client
+}
+
+// relayRunning reports whether a relay answers health checks on the
+// configured address.
+func relayRunning(config *Config) bool {
+	resp, err := localHTTPClient(config, 2*time.Second).Get(localRelayURL(config) + "/health")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// newAdminRequest builds an authenticated request against a running relay's
+// admin API using the token it left in the data directory.
+func newAdminRequest(config *Config, method, path string) (*http.Request, error) {
+	token, err := readAdminToken(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, localRelayURL(config)+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	evbadger "github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	backupPrefix = "tenex-relay-"
+	backupSuffix = ".badger.gz"
+)
+
+// backupManager takes consistent online snapshots of the Badger store.
+// Badger streams a backup from a single read timestamp, so snapshots can run
+// while the relay keeps accepting writes.
+type backupManager struct {
+	db        *evbadger.BadgerBackend
+	dir       string
+	retention int
+
+	mu sync.Mutex // serializes snapshots
+	wg sync.WaitGroup
+}
+
+// BackupInfo describes a snapshot written to the backup directory.
+type BackupInfo struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Duration string `json:"duration"`
+}
+
+func newBackupManager(config BackupConfig, dataDir string, db *evbadger.BadgerBackend) *backupManager {
+	return &backupManager{
+		db:        db,
+		dir:       config.resolvedDir(dataDir),
+		retention: config.Retention,
+	}
+}
+
+// Snapshot writes a full gzipped Badger backup and prunes old snapshots
+// beyond the retention count.
+func (b *backupManager) Snapshot() (*BackupInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	// Names carry microseconds so a scheduled snapshot and an admin one
+	// taken in the same second don't collide; should two names still
+	// match, O_EXCL and the hard link below fail rather than overwrite.
+	start := time.Now()
+	name := backupPrefix + start.UTC().Format("20060102T150405.000000Z") + backupSuffix
+	path := filepath.Join(b.dir, name)
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(f)
+	_, err = b.db.DB.Backup(gz, 0)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Link(tmpPath, path)
+	}
+	os.Remove(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("backup failed: %w", err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{
+		Path:     path,
+		Size:     stat.Size(),
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+	}
+	logBackup.Info("wrote snapshot", "path", path, "bytes", info.Size, "duration", info.Duration)
+
+	if err := b.prune(); err != nil {
+		logBackup.Warn("failed to prune old snapshots", "err", err)
+	}
+	return info, nil
+}
+
+// prune deletes the oldest snapshots so at most retention remain.
+func (b *backupManager) prune() error {
+	if b.retention <= 0 {
+		return nil
+	}
+	backups, err := listBackups(b.dir)
+	if err != nil {
+		return err
+	}
+	for len(backups) > b.retention {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		logBackup.Info("removed old snapshot", "path", backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Start takes a snapshot every interval until ctx is canceled.
+func (b *backupManager) Start(ctx context.Context, interval time.Duration) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := b.Snapshot(); err != nil {
+					logBackup.Error("scheduled snapshot failed", "err", err)
+				}
+			}
+		}
+	}()
+	logBackup.Info("scheduled snapshots", "interval", interval, "path", b.dir, "retention", b.retention)
+}
+
+// Wait blocks until the scheduler started by Start has returned, after its
+// context is canceled.
+func (b *backupManager) Wait() {
+	b.wg.Wait()
+}
+
+// listBackups returns completed snapshots in dir, oldest first. The UTC
+// timestamp in the file name sorts lexically.
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (r *Relay) handleSnapshot(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.backups == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("snapshots require the badger backend (configured: %s)", r.config.Storage.Backend),
+		})
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	info, err := r.backups.Snapshot()
+	if err != nil {
+		logBackup.Error("admin snapshot failed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(info)
+}
+
+// runRestore validates a snapshot by loading it into a scratch Badger
+// directory and reading every event back, then swaps it into place. The
+// current store is kept next to it as badger.pre-restore-<timestamp>.
+// Usage: tenex-relay restore <backup-file>
+func runRestore(config *Config, backupPath string) error {
+	if config.Storage.Backend != storageBadger {
+		return fmt.Errorf("restore requires the badger backend (configured: %s)", config.Storage.Backend)
+	}
+	if relayRunning(config) {
+		return errors.New("the relay is running; stop it before restoring")
+	}
+
+	livePath := config.Storage.resolvedPath(config.DataDir)
+	scratchPath := livePath + ".restore-tmp"
+	if err := os.RemoveAll(scratchPath); err != nil {
+		return err
+	}
+
+	log.Printf("Validating %s...", backupPath)
+	count, err := loadBackup(backupPath, scratchPath)
+	if err != nil {
+		os.RemoveAll(scratchPath)
+		return fmt.Errorf("backup failed validation: %w", err)
+	}
+	log.Printf("Backup is valid: %d events", count)
+
+	if _, err := os.Stat(livePath); err == nil {
+		keepPath := fmt.Sprintf("%s.pre-restore-%s", livePath, time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.Rename(livePath, keepPath); err != nil {
+			os.RemoveAll(scratchPath)
+			return fmt.Errorf("failed to move current store aside: %w", err)
+		}
+		log.Printf("Previous store kept at %s", keepPath)
+	}
+	if err := os.Rename(scratchPath, livePath); err != nil {
+		return fmt.Errorf("failed to move restored store into place: %w", err)
+	}
+
+	// The search index and sync cursors describe the old store.
+	if err := resetSearchIndex(config.DataDir); err != nil {
+		return err
+	}
+	if err := os.Remove(syncCursorsPath(config.DataDir)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	log.Printf("Restore complete: %s", livePath)
+	return nil
+}
+
+// loadBackup loads a gzipped Badger backup into a fresh store at path and
+// reads back every event to prove the result is usable.
+func loadBackup(backupPath, path string) (int, error) {
+	f, err := os.Open(backupPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(backupPath, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open gzip: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	raw, err := badger.Open(silentBadger(badger.DefaultOptions(path)))
+	if err != nil {
+		return 0, err
+	}
+	if err := loadBadgerStream(raw, reader); err != nil {
+		raw.Close()
+		return 0, fmt.Errorf("failed to load backup: %w", err)
+	}
+	if err := raw.Close(); err != nil {
+		return 0, err
+	}
+
+	db, err := openBadger(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	count := 0
+	err = scanEvents(context.Background(), db, nostr.Filter{}, func(evt *nostr.Event) error {
+		if evt.ID == "" || evt.PubKey == "" {
+			return fmt.Errorf("corrupt event record after %d events", count)
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// loadBadgerStream wraps DB.Load, which panics rather than erroring on some
+// malformed input.
+func loadBadgerStream(db *badger.DB, r io.Reader) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("malformed backup stream: %v", p)
+		}
+	}()
+	return db.Load(r, 256)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// banList holds pubkeys an admin has banned. Their EVENTs are rejected,
+// whoever sends them, and they can't open subscriptions once authenticated.
+// The list is kept in <data_dir>/banned_pubkeys.json.
+type banList struct {
+	path string
+
+	mu   sync.RWMutex
+	bans map[string]ban
+}
+
+type ban struct {
+	Pubkey   string    `json:"pubkey"`
+	Reason   string    `json:"reason,omitempty"`
+	BannedAt time.Time `json:"banned_at"`
+}
+
+func bansPath(dataDir string) string {
+	return filepath.Join(dataDir, "banned_pubkeys.json")
+}
+
+func loadBanList(dataDir string) (*banList, error) {
+	b := &banList{path: bansPath(dataDir), bans: make(map[string]ban)}
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bans []ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", b.path, err)
+	}
+	for _, entry := range bans {
+		b.bans[entry.Pubkey] = entry
+	}
+	return b, nil
+}
+
+func (b *banList) IsBanned(pubkey string) bool {
+	if pubkey == "" {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.bans[pubkey]
+	return ok
+}
+
+// List returns the bans, most recent first.
+func (b *banList) List() []ban {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	list := make([]ban, 0, len(b.bans))
+	for _, entry := range b.bans {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].BannedAt.After(list[j].BannedAt) })
+	return list
+}
+
+func (b *banList) Ban(pubkey, reason string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bans[pubkey] = ban{Pubkey: pubkey, Reason: reason, BannedAt: time.Now().UTC()}
+	return b.save()
+}
+
+// Unban lifts a ban, reporting whether there was one.
+func (b *banList) Unban(pubkey string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.bans[pubkey]; !ok {
+		return false, nil
+	}
+	delete(b.bans, pubkey)
+	return true, b.save()
+}
+
+// save must be called with b.mu held.
+func (b *banList) save() error {
+	list := make([]ban, 0, len(b.bans))
+	for _, entry := range b.bans {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Pubkey < list[j].Pubkey })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save bans: %w", err)
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save bans: %w", err)
+	}
+	return nil
+}
+
+// RejectEvent blocks events authored or sent by a banned pubkey.
+func (b *banList) RejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if b.IsBanned(event.PubKey) || b.IsBanned(khatru.GetAuthed(ctx)) {
+		return true, "blocked: pubkey is banned"
+	}
+	return false, ""
+}
+
+// RejectFilter blocks subscriptions from a banned authenticated pubkey.
+func (b *banList) RejectFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	if b.IsBanned(khatru.GetAuthed(ctx)) {
+		return true, "blocked: pubkey is banned"
+	}
+	return false, ""
+}
+
+// handleBans lists bans on GET and adds one on POST, with a body of
+// {"pubkey": "<hex or npub>", "reason": "spam"}. Connections authenticated
+// as the pubkey are closed.
+func (r *Relay) handleBans(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method == http.MethodPost {
+		var body struct {
+			Pubkey string `json:"pubkey"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "expected a JSON body like {\"pubkey\": \"npub1...\", \"reason\": \"spam\"}"})
+			return
+		}
+		pubkey := npubToHex(body.Pubkey)
+		if !nostr.IsValidPublicKey(pubkey) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": fmt.Sprintf("%q is not a hex or npub pubkey", body.Pubkey)})
+			return
+		}
+		if r.acl.IsAdmin(pubkey) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "admin pubkeys can't be banned; remove them from admin_pubkeys first"})
+			return
+		}
+		if err := r.bans.Ban(pubkey, body.Reason); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		closed := r.conns.closeWhere(func(ws *khatru.WebSocket) bool { return ws.AuthedPublicKey == pubkey }, "blocked: pubkey is banned")
+		logRelay.Info("banned pubkey", "pubkey", pubkey, "reason", body.Reason, "connections_closed", closed)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"bans": r.bans.List()})
+}
+
+// handleUnban lifts the ban on the pubkey in the path.
+func (r *Relay) handleUnban(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	pubkey := npubToHex(req.PathValue("pubkey"))
+	removed, err := r.bans.Unban(pubkey)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if !removed {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "pubkey is not banned"})
+		return
+	}
+	logRelay.Info("unbanned pubkey", "pubkey", pubkey)
+	json.NewEncoder(w).Encode(map[string]interface{}{"bans": r.bans.List()})
+}
@@ -3,20 +3,55 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
 // Config represents the relay configuration
 type Config struct {
-	Port         int          `json:"port"`
-	BindAddress  string       `json:"bind_address"`
-	DataDir      string       `json:"data_dir"`
-	NIP11        NIP11Config  `json:"nip11"`
-	Limits       LimitsConfig `json:"limits"`
-	Sync         SyncConfig   `json:"sync"`
-	AdminPubkeys []string     `json:"admin_pubkeys"`
+	// ConfigVersion is the schema version the file was written for; older
+	// files are migrated when loaded.
+	ConfigVersion int `json:"config_version"`
+
+	Port         int           `json:"port"`
+	BindAddress  string        `json:"bind_address"`
+	DataDir      string        `json:"data_dir"`
+	NIP11        NIP11Config   `json:"nip11"`
+	Limits       LimitsConfig  `json:"limits"`
+	Storage      StorageConfig `json:"storage"`
+	Sync         SyncConfig    `json:"sync"`
+	Search       SearchConfig  `json:"search"`
+	Backup       BackupConfig  `json:"backup"`
+	Disk         DiskConfig    `json:"disk"`
+	Quota        QuotaConfig   `json:"quota"`
+	AdminPubkeys []string      `json:"admin_pubkeys"`
+	TLS          TLSConfig     `json:"tls"`
+	// Mode is normal, read-only or maintenance. Signals and the admin API
+	// can switch it until the config's mode next changes.
+	Mode string `json:"mode"`
+	// ShutdownTimeoutSeconds bounds how long shutdown waits for clients to
+	// be told, in-flight writes and background work before the store is
+	// closed regardless.
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+	// Log sets the log format, levels and output file.
+	Log LogConfig `json:"log"`
+	// UnixSocket, if set, is a path where the relay also listens for local
+	// clients, alongside the TCP port.
+	UnixSocket string `json:"unix_socket,omitempty"`
+	// Policy applies to connections on port/bind_address and unix_socket.
+	Policy ListenerPolicy `json:"policy"`
+	// Listeners are extra TCP listeners with policies of their own, e.g. a
+	// LAN-facing port with stricter rules beside the trusted loopback one.
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
 }
 
 // NIP11Config contains all NIP-11 relay information document fields
@@ -28,24 +63,210 @@ type NIP11Config struct {
 	SupportedNIPs []int  `json:"supported_nips"`
 	Software      string `json:"software"`
 	Version       string `json:"version"`
+	Icon          string `json:"icon,omitempty"`
+	Banner        string `json:"banner,omitempty"`
+	PrivacyPolicy string `json:"privacy_policy,omitempty"`
+	// Retention is published as-is; ephemeral kinds are always listed with
+	// limits.ephemeral_retention_seconds.
+	Retention []RetentionConfig `json:"retention,omitempty"`
+}
+
+// RetentionConfig is one NIP-11 retention entry. Kinds mixes single kinds
+// and [from, to] ranges; a zero Time or Count means unlimited.
+type RetentionConfig struct {
+	Kinds []KindRange `json:"kinds,omitempty"`
+	Time  int64       `json:"time,omitempty"`
+	Count int         `json:"count,omitempty"`
+}
+
+// KindRange is a kind or an inclusive range of kinds, written as 1 or
+// [30000, 39999].
+type KindRange struct {
+	From int
+	To   int
+}
+
+func (k KindRange) MarshalJSON() ([]byte, error) {
+	if k.From == k.To {
+		return json.Marshal(k.From)
+	}
+	return json.Marshal([2]int{k.From, k.To})
+}
+
+func (k *KindRange) UnmarshalJSON(data []byte) error {
+	var kind int
+	if err := json.Unmarshal(data, &kind); err == nil {
+		k.From, k.To = kind, kind
+		return nil
+	}
+	var bounds [2]int
+	if err := json.Unmarshal(data, &bounds); err != nil {
+		return fmt.Errorf("kind must be a number or a [from, to] range: %s", data)
+	}
+	k.From, k.To = bounds[0], bounds[1]
+	return nil
 }
 
 // LimitsConfig contains relay limits
 type LimitsConfig struct {
-	MaxMessageLength int `json:"max_message_length"`
-	MaxSubscriptions int `json:"max_subscriptions"`
-	MaxFilters       int `json:"max_filters"`
-	MaxEventTags     int `json:"max_event_tags"`
-	MaxContentLength int `json:"max_content_length"`
-	DefaultQueryLimit int `json:"default_query_limit"`
-	MaxQueryLimit int `json:"max_query_limit"`
+	MaxMessageLength    int `json:"max_message_length"`
+	MaxSubscriptions    int `json:"max_subscriptions"`
+	MaxFilters          int `json:"max_filters"`
+	MaxEventTags        int `json:"max_event_tags"`
+	MaxContentLength    int `json:"max_content_length"`
+	DefaultQueryLimit   int `json:"default_query_limit"`
+	MaxQueryLimit       int `json:"max_query_limit"`
 	MaxQueryWindowHours int `json:"max_query_window_hours"`
+
+	// MaxSubscriptionsPerPubkey caps open subscriptions across all of an
+	// authenticated pubkey's connections. Subscription and filter caps of 0
+	// are unlimited.
+	MaxSubscriptionsPerPubkey int `json:"max_subscriptions_per_pubkey"`
+
+	// EVENT rate limits per publisher, split by kind class so chatty
+	// ephemeral traffic doesn't eat into the budget for stored events.
+	EventRate          RateLimit `json:"event_rate"`
+	EphemeralEventRate RateLimit `json:"ephemeral_event_rate"`
+	// Per-IP limits on REQ/COUNT filters and new connections.
+	FilterRate     RateLimit `json:"filter_rate"`
+	ConnectionRate RateLimit `json:"connection_rate"`
+
+	// HistoricalReplayWindowSeconds is how long an identical historical
+	// query from the same client is answered with no stored events; 0
+	// disables the guard.
+	HistoricalReplayWindowSeconds int `json:"historical_replay_window_seconds"`
+	// EphemeralRetentionSeconds is how long ephemeral events stay queryable
+	// after they are broadcast.
+	EphemeralRetentionSeconds int `json:"ephemeral_retention_seconds"`
+
+	// Clients exempt from the rate limits above: IPs or CIDR ranges, and
+	// hex pubkeys, which must be authenticated with NIP-42: an EVENT's
+	// author alone doesn't exempt it, since anyone can rebroadcast it.
+	RateLimitExemptIPs     []string `json:"rate_limit_exempt_ips"`
+	RateLimitExemptPubkeys []string `json:"rate_limit_exempt_pubkeys"`
+}
+
+// RateLimit is a token bucket: PerSecond tokens are added each second, up
+// to Burst. A PerSecond of 0 disables the limit.
+type RateLimit struct {
+	PerSecond float64 `json:"per_second"`
+	Burst     int     `json:"burst"`
+}
+
+// TLSConfig serves the TCP port over TLS (wss://). Without CertFile and
+// KeyFile a self-signed certificate is generated under data_dir; its
+// fingerprint is logged at startup so clients can pin it. Hosts adds DNS
+// names or IPs to the self-signed certificate beyond this machine's own.
+type TLSConfig struct {
+	Enabled  bool     `json:"enabled"`
+	CertFile string   `json:"cert_file,omitempty"`
+	KeyFile  string   `json:"key_file,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+}
+
+// ListenerConfig is an extra TCP listener. TLS serves it with the
+// certificate from the tls section, whether or not tls.enabled is set.
+type ListenerConfig struct {
+	Name        string         `json:"name"`
+	BindAddress string         `json:"bind_address"`
+	Port        int            `json:"port"`
+	TLS         bool           `json:"tls,omitempty"`
+	Policy      ListenerPolicy `json:"policy"`
+}
+
+// ListenerPolicy adjusts how the relay treats connections accepted by one
+// listener. The zero value applies the global settings unchanged.
+type ListenerPolicy struct {
+	// Trusted connections skip NIP-42, the whitelist, rate limits and
+	// subscription caps; meant for the local TENEX daemon on loopback.
+	Trusted bool `json:"trusted,omitempty"`
+	// RequireAuth demands NIP-42 before any EVENT, REQ or COUNT, including
+	// ephemeral-only subscriptions.
+	RequireAuth bool `json:"require_auth,omitempty"`
+	// ReadOnly rejects every EVENT.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Rate limits replacing the ones in limits for this listener.
+	EventRate          *RateLimit `json:"event_rate,omitempty"`
+	EphemeralEventRate *RateLimit `json:"ephemeral_event_rate,omitempty"`
+	FilterRate         *RateLimit `json:"filter_rate,omitempty"`
+	ConnectionRate     *RateLimit `json:"connection_rate,omitempty"`
+}
+
+// LogConfig controls the relay's logs. Components overrides Level for some
+// subsystems, e.g. "acl=debug,sync=warn". Logs go to stderr unless File is
+// set; the file is rotated when it reaches MaxSizeMB, keeping MaxBackups
+// older files.
+type LogConfig struct {
+	Format     string `json:"format"`
+	Level      string `json:"level"`
+	Components string `json:"components,omitempty"`
+	File       string `json:"file,omitempty"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+}
+
+// StorageConfig selects the event store backend: badger, lmdb, sqlite or
+// memory. lmdb and sqlite need a build with cgo; release builds have none.
+// Path defaults to a backend-specific location under data_dir.
+type StorageConfig struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path,omitempty"`
+}
+
+// BackupConfig schedules online snapshots of the Badger store. Dir defaults
+// to <data_dir>/backups; Retention is how many snapshots to keep.
+type BackupConfig struct {
+	Enabled       bool   `json:"enabled"`
+	IntervalHours int    `json:"interval_hours"`
+	Retention     int    `json:"retention"`
+	Dir           string `json:"dir,omitempty"`
+}
+
+func (b BackupConfig) resolvedDir(dataDir string) string {
+	if b.Dir != "" {
+		return expandPath(b.Dir)
+	}
+	return filepath.Join(dataDir, "backups")
+}
+
+// DiskConfig controls Badger space reclamation. Value-log GC runs every
+// GCIntervalMinutes. When MaxSizeMB is set and the store outgrows it, events
+// of PruneKinds are deleted oldest first, one kind at a time in the order
+// listed, until usage is back under the cap.
+type DiskConfig struct {
+	GCIntervalMinutes int   `json:"gc_interval_minutes"`
+	MaxSizeMB         int   `json:"max_size_mb"`
+	PruneKinds        []int `json:"prune_kinds"`
+}
+
+// QuotaConfig caps how much each author may store. Zero means no limit.
+// Kinds sets separate caps for individual kinds, on top of the totals.
+// Admin pubkeys are exempt.
+type QuotaConfig struct {
+	Enabled   bool              `json:"enabled"`
+	MaxEvents int               `json:"max_events"`
+	MaxBytes  int64             `json:"max_bytes"`
+	Kinds     map[int]KindQuota `json:"kinds,omitempty"`
+}
+
+// KindQuota caps one kind per author
+type KindQuota struct {
+	MaxEvents int   `json:"max_events"`
+	MaxBytes  int64 `json:"max_bytes"`
+}
+
+// SearchConfig controls the NIP-50 full-text index
+type SearchConfig struct {
+	Enabled bool  `json:"enabled"`
+	Kinds   []int `json:"kinds"`
 }
 
-// SyncConfig contains relay sync settings
+// SyncConfig contains relay sync settings. MinConnected is how many relays
+// must be connected for /ready to pass; 0 leaves sync out of readiness.
 type SyncConfig struct {
-	Relays []string `json:"relays"`
-	Kinds  []int    `json:"kinds"`
+	Relays       []string `json:"relays"`
+	Kinds        []int    `json:"kinds"`
+	MinConnected int      `json:"min_connected,omitempty"`
 }
 
 func defaultDataDir() string {
@@ -61,6 +282,7 @@ func DefaultConfig() *Config {
 		Port:        7777,
 		BindAddress: "127.0.0.1",
 		DataDir:     defaultDataDir(),
+		Mode:        modeNormal,
 		NIP11: NIP11Config{
 			Name:          "TENEX Local Relay",
 			Description:   "Local Nostr relay for TENEX",
@@ -71,76 +293,426 @@ func DefaultConfig() *Config {
 			Version:       "0.1.0",
 		},
 		Limits: LimitsConfig{
-			MaxMessageLength: 2097152,
-			MaxSubscriptions: 200,
-			MaxFilters:       50,
-			MaxEventTags:     8192,
-			MaxContentLength: 1048576,
-			DefaultQueryLimit: 100,
-			MaxQueryLimit: 500,
+			MaxMessageLength:    2097152,
+			MaxSubscriptions:    200,
+			MaxFilters:          50,
+			MaxEventTags:        8192,
+			MaxContentLength:    1048576,
+			DefaultQueryLimit:   100,
+			MaxQueryLimit:       500,
 			MaxQueryWindowHours: 168,
+			EventRate:           RateLimit{PerSecond: 20, Burst: 100},
+			EphemeralEventRate:  RateLimit{PerSecond: 50, Burst: 200},
+			FilterRate:          RateLimit{PerSecond: 20, Burst: 40},
+			ConnectionRate:      RateLimit{PerSecond: 10, Burst: 20},
+
+			MaxSubscriptionsPerPubkey:     1000,
+			HistoricalReplayWindowSeconds: 5,
+			EphemeralRetentionSeconds:     60,
+		},
+		Storage: StorageConfig{
+			Backend: storageBadger,
 		},
 		Sync: SyncConfig{
 			Relays: []string{"wss://relay.tenex.chat"},
 			Kinds:  []int{1, 4199, 14199, 4129, 4200, 4201, 4202, 34199, 30023},
 		},
+		Search: SearchConfig{
+			Enabled: true,
+			Kinds:   []int{1, 30023},
+		},
+		Backup: BackupConfig{
+			Enabled:       false,
+			IntervalHours: 24,
+			Retention:     7,
+		},
+		Disk: DiskConfig{
+			GCIntervalMinutes: 10,
+			MaxSizeMB:         0,
+			PruneKinds:        []int{7, 6, 16, 9735},
+		},
+		Quota: QuotaConfig{
+			Enabled:   false,
+			MaxEvents: 100000,
+			MaxBytes:  268435456,
+		},
+		ConfigVersion:          currentConfigVersion,
+		ShutdownTimeoutSeconds: 10,
+		Log: LogConfig{
+			Format:     logFormatText,
+			Level:      "info",
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
 	}
 }
 
 // LoadConfig loads configuration from the given path
-// If the file doesn't exist, it returns the default config
+// If the file doesn't exist, it starts from the default config
 func LoadConfig(path string) (*Config, error) {
-	path = expandPath(path)
-
-	// Check if config file exists
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		return DefaultConfig(), nil
-	}
+	config, _, err := loadConfigWithSources(path, nil)
+	return config, err
+}
 
-	data, err := os.ReadFile(path)
+// loadConfigWithSources layers the defaults, the config file, TENEX_RELAY_*
+// environment variables and -set key=value overrides, then validates the
+// result. It also reports where each value that isn't a default came from.
+// Files written for an older config_version are migrated in memory only;
+// upgradeConfigFile rewrites them when the relay starts.
+func loadConfigWithSources(path string, sets []string) (*Config, map[string]string, error) {
+	path = expandPath(path)
+	data, _, err := readConfigFile(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	logUnknownConfigKeys(path, data)
 
-	// Start with defaults and overlay loaded config
+	return buildConfig(data, sets)
+}
+
+// buildConfig overlays a config file's contents, which may be nil, and the
+// overrides onto the defaults and validates the result.
+func buildConfig(data []byte, sets []string) (*Config, map[string]string, error) {
 	config := DefaultConfig()
-	if err := json.Unmarshal(data, config); err != nil {
-		return nil, err
+	sources := make(map[string]string)
+
+	if data != nil {
+		// Start with defaults and overlay loaded config
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, nil, describeJSONError(data, err)
+		}
+		for key := range fileKeys(data) {
+			sources[key] = sourceFile
+		}
+	}
+
+	if err := applyEnvOverrides(config, sources); err != nil {
+		return nil, nil, err
+	}
+	if err := applySetOverrides(config, sets, sources); err != nil {
+		return nil, nil, err
 	}
 
-	// Expand paths
+	// Expand paths and accept npub pubkeys
 	config.DataDir = expandPath(config.DataDir)
+	config.normalizePubkeys()
 
 	// Validate
 	if err := config.Validate(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return config, nil
+	return config, sources, nil
 }
 
-// Validate checks if the configuration is valid
+// Validate checks if the configuration is valid. It reports every problem
+// it finds, joined, rather than stopping at the first.
 func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
 	if c.Port < 1 || c.Port > 65535 {
-		return errors.New("port must be between 1 and 65535")
+		fail("port must be between 1 and 65535, got %d", c.Port)
+	}
+
+	if c.BindAddress == "" {
+		fail("bind_address cannot be empty; use 127.0.0.1 for local-only or 0.0.0.0 for all interfaces")
 	}
 
 	if c.DataDir == "" {
-		return errors.New("data_dir cannot be empty")
+		fail("data_dir cannot be empty")
+	}
+
+	if !isKnownMode(c.Mode) {
+		fail("mode must be one of %s, %s, %s, got %q", modeNormal, modeReadOnly, modeMaintenance, c.Mode)
+	}
+
+	if c.ShutdownTimeoutSeconds < 1 {
+		fail("shutdown_timeout_seconds must be at least 1, got %d", c.ShutdownTimeoutSeconds)
+	}
+
+	if c.Log.Format != logFormatText && c.Log.Format != logFormatJSON {
+		fail("log.format must be %s or %s, got %q", logFormatText, logFormatJSON, c.Log.Format)
+	}
+	if _, err := parseLogLevel(c.Log.Level); err != nil {
+		fail("log.level: %v", err)
+	}
+	if _, err := parseComponentLevels(c.Log.Components); err != nil {
+		fail("log.components: %v", err)
+	}
+	if c.Log.MaxSizeMB < 1 {
+		fail("log.max_size_mb must be at least 1, got %d", c.Log.MaxSizeMB)
+	}
+	if c.Log.MaxBackups < 0 {
+		fail("log.max_backups cannot be negative, got %d", c.Log.MaxBackups)
+	}
+
+	for i, nip := range c.NIP11.SupportedNIPs {
+		if nip < 1 {
+			fail("nip11.supported_nips[%d] must be a positive NIP number, got %d", i, nip)
+		}
+	}
+	if c.NIP11.Pubkey != "" && !nostr.IsValid32ByteHex(c.NIP11.Pubkey) {
+		fail("nip11.pubkey: %q is not a hex or npub pubkey", c.NIP11.Pubkey)
+	}
+	for _, field := range []struct{ name, value string }{
+		{"nip11.icon", c.NIP11.Icon},
+		{"nip11.banner", c.NIP11.Banner},
+		{"nip11.privacy_policy", c.NIP11.PrivacyPolicy},
+	} {
+		if field.value != "" {
+			if err := validateURL(field.value, "http", "https"); err != nil {
+				fail("%s: %v", field.name, err)
+			}
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls.cert_file and tls.key_file must be set together; leave both empty for a self-signed certificate")
+	}
+
+	c.Policy.validate("policy", fail)
+	addrs := map[string]string{net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Port)): "port"}
+	names := map[string]bool{mainListener: true}
+	for i, l := range c.Listeners {
+		name := fmt.Sprintf("listeners[%d]", i)
+		if l.Name == "" {
+			fail("%s.name cannot be empty", name)
+		} else if names[l.Name] {
+			fail("%s.name %q is already used; listener names must be unique and not %q", name, l.Name, mainListener)
+		}
+		names[l.Name] = true
+		if l.BindAddress == "" {
+			fail("%s.bind_address cannot be empty", name)
+		}
+		if l.Port < 1 || l.Port > 65535 {
+			fail("%s.port must be between 1 and 65535, got %d", name, l.Port)
+		}
+		addr := net.JoinHostPort(l.BindAddress, strconv.Itoa(l.Port))
+		if other, ok := addrs[addr]; ok {
+			fail("%s listens on %s, same as %s", name, addr, other)
+		}
+		addrs[addr] = name
+		l.Policy.validate(name+".policy", fail)
+	}
+
+	if !isKnownStorageBackend(c.Storage.Backend) {
+		fail("storage.backend must be one of badger, lmdb, sqlite, memory, got %q", c.Storage.Backend)
+	} else if !cgoStorage && (c.Storage.Backend == storageLMDB || c.Storage.Backend == storageSQLite) {
+		fail("storage.backend %s is not included in this build, which was made without cgo; use badger or memory", c.Storage.Backend)
+	}
+
+	if c.Backup.Enabled {
+		if c.Storage.Backend != storageBadger {
+			fail("backup.enabled requires storage.backend badger")
+		}
+		if c.Backup.IntervalHours < 1 {
+			fail("backup.interval_hours must be greater than 0")
+		}
+	}
+	if c.Backup.Retention < 0 {
+		fail("backup.retention cannot be negative")
+	}
+
+	if c.Disk.GCIntervalMinutes < 1 {
+		fail("disk.gc_interval_minutes must be greater than 0")
+	}
+
+	if c.Disk.MaxSizeMB < 0 {
+		fail("disk.max_size_mb cannot be negative")
+	}
+
+	if c.Disk.MaxSizeMB > 0 {
+		if c.Storage.Backend != storageBadger {
+			fail("disk.max_size_mb requires storage.backend badger")
+		}
+		if len(c.Disk.PruneKinds) == 0 {
+			fail("disk.max_size_mb requires at least one disk.prune_kinds entry")
+		}
+		if slices.Contains(c.Disk.PruneKinds, 14199) {
+			fail("disk.prune_kinds cannot include 14199: whitelist events drive access control")
+		}
+	}
+	validateKinds("disk.prune_kinds", c.Disk.PruneKinds, fail)
+
+	if c.Quota.MaxEvents < 0 || c.Quota.MaxBytes < 0 {
+		fail("quota.max_events and quota.max_bytes cannot be negative")
+	}
+	for kind, q := range c.Quota.Kinds {
+		if !isValidKind(kind) {
+			fail("quota.kinds: %d is not a valid kind (0-65535)", kind)
+		}
+		if q.MaxEvents < 0 || q.MaxBytes < 0 {
+			fail("quota.kinds.%d limits cannot be negative", kind)
+		}
+	}
+
+	validateKinds("search.kinds", c.Search.Kinds, fail)
+
+	validateKinds("sync.kinds", c.Sync.Kinds, fail)
+	if c.Sync.MinConnected < 0 || c.Sync.MinConnected > len(c.Sync.Relays) {
+		fail("sync.min_connected must be between 0 and the number of sync.relays (%d), got %d", len(c.Sync.Relays), c.Sync.MinConnected)
+	}
+	for i, relay := range c.Sync.Relays {
+		if err := validateURL(relay, "ws", "wss"); err != nil {
+			fail("sync.relays[%d]: %v", i, err)
+		}
+	}
+
+	for i, r := range c.NIP11.Retention {
+		if r.Time < 0 || r.Count < 0 {
+			fail("nip11.retention[%d] time and count cannot be negative", i)
+		}
+		for _, k := range r.Kinds {
+			if !isValidKind(k.From) || !isValidKind(k.To) || k.To < k.From {
+				fail("nip11.retention[%d] has an invalid kind range [%d, %d]", i, k.From, k.To)
+			}
+		}
+	}
+
+	if c.Limits.MaxMessageLength < 1 {
+		fail("limits.max_message_length must be greater than 0")
+	}
+	if c.Limits.MaxEventTags < 1 {
+		fail("limits.max_event_tags must be greater than 0")
+	}
+	if c.Limits.MaxContentLength < 1 {
+		fail("limits.max_content_length must be greater than 0")
+	} else if c.Limits.MaxMessageLength > 0 && c.Limits.MaxContentLength > c.Limits.MaxMessageLength {
+		fail("limits.max_content_length (%d) cannot exceed limits.max_message_length (%d): larger events never reach the content check",
+			c.Limits.MaxContentLength, c.Limits.MaxMessageLength)
+	}
+
+	if c.Limits.MaxSubscriptions < 0 || c.Limits.MaxSubscriptionsPerPubkey < 0 || c.Limits.MaxFilters < 0 {
+		fail("limits.max_subscriptions, limits.max_subscriptions_per_pubkey and limits.max_filters cannot be negative")
 	}
 
 	if c.Limits.DefaultQueryLimit < 1 {
-		return errors.New("limits.default_query_limit must be greater than 0")
+		fail("limits.default_query_limit must be greater than 0")
 	}
 
 	if c.Limits.MaxQueryLimit < c.Limits.DefaultQueryLimit {
-		return errors.New("limits.max_query_limit must be greater than or equal to limits.default_query_limit")
+		fail("limits.max_query_limit (%d) must be greater than or equal to limits.default_query_limit (%d)",
+			c.Limits.MaxQueryLimit, c.Limits.DefaultQueryLimit)
 	}
 
 	if c.Limits.MaxQueryWindowHours < 1 {
-		return errors.New("limits.max_query_window_hours must be greater than 0")
+		fail("limits.max_query_window_hours must be greater than 0")
+	}
+
+	for _, err := range []error{
+		c.Limits.EventRate.validate("limits.event_rate"),
+		c.Limits.EphemeralEventRate.validate("limits.ephemeral_event_rate"),
+		c.Limits.FilterRate.validate("limits.filter_rate"),
+		c.Limits.ConnectionRate.validate("limits.connection_rate"),
+	} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Limits.HistoricalReplayWindowSeconds < 0 {
+		fail("limits.historical_replay_window_seconds cannot be negative")
+	}
+	if c.Limits.EphemeralRetentionSeconds < 1 {
+		fail("limits.ephemeral_retention_seconds must be greater than 0")
+	}
+
+	for i, entry := range c.Limits.RateLimitExemptIPs {
+		if _, err := parseIPPrefix(entry); err != nil {
+			fail("limits.rate_limit_exempt_ips[%d]: %v", i, err)
+		}
+	}
+	for i, pubkey := range c.Limits.RateLimitExemptPubkeys {
+		if !nostr.IsValid32ByteHex(pubkey) {
+			fail("limits.rate_limit_exempt_pubkeys[%d]: %q is not a hex or npub pubkey", i, pubkey)
+		}
+	}
+	for i, pubkey := range c.AdminPubkeys {
+		if !nostr.IsValid32ByteHex(pubkey) {
+			fail("admin_pubkeys[%d]: %q is not a hex or npub pubkey", i, pubkey)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isValidKind(kind int) bool {
+	return kind >= 0 && kind <= 65535
+}
+
+func validateKinds(name string, kinds []int, fail func(string, ...any)) {
+	for i, kind := range kinds {
+		if !isValidKind(kind) {
+			fail("%s[%d]: %d is not a valid kind (0-65535)", name, i, kind)
+		}
+	}
+}
+
+// validateURL checks that value is an absolute URL with a host and one of
+// the given schemes.
+func validateURL(value string, schemes ...string) error {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" || !slices.Contains(schemes, u.Scheme) {
+		return fmt.Errorf("%q must be a %s:// URL", value, strings.Join(schemes, ":// or "))
 	}
+	return nil
+}
 
+// normalizePubkeys rewrites npub-encoded pubkeys as hex, the form the rest
+// of the relay compares against. Anything that doesn't decode is left for
+// Validate to report.
+func (c *Config) normalizePubkeys() {
+	c.NIP11.Pubkey = npubToHex(c.NIP11.Pubkey)
+	for i, pubkey := range c.AdminPubkeys {
+		c.AdminPubkeys[i] = npubToHex(pubkey)
+	}
+	for i, pubkey := range c.Limits.RateLimitExemptPubkeys {
+		c.Limits.RateLimitExemptPubkeys[i] = npubToHex(pubkey)
+	}
+}
+
+func npubToHex(pubkey string) string {
+	if !strings.HasPrefix(pubkey, "npub1") {
+		return pubkey
+	}
+	prefix, value, err := nip19.Decode(pubkey)
+	if err != nil || prefix != "npub" {
+		return pubkey
+	}
+	return value.(string)
+}
+
+func (p ListenerPolicy) validate(name string, fail func(string, ...any)) {
+	if p.Trusted && (p.RequireAuth || p.ReadOnly) {
+		fail("%s.trusted cannot be combined with require_auth or read_only", name)
+	}
+	for _, rate := range []struct {
+		name  string
+		limit *RateLimit
+	}{
+		{"event_rate", p.EventRate},
+		{"ephemeral_event_rate", p.EphemeralEventRate},
+		{"filter_rate", p.FilterRate},
+		{"connection_rate", p.ConnectionRate},
+	} {
+		if rate.limit != nil {
+			if err := rate.limit.validate(name + "." + rate.name); err != nil {
+				fail("%v", err)
+			}
+		}
+	}
+}
+
+func (l RateLimit) validate(name string) error {
+	if l.PerSecond < 0 {
+		return fmt.Errorf("%s.per_second cannot be negative", name)
+	}
+	if l.PerSecond > 0 && l.Burst < 1 {
+		return fmt.Errorf("%s.burst must be greater than 0", name)
+	}
 	return nil
 }
 
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// currentConfigVersion is the config schema this build reads and writes.
+// Files without config_version are version 0.
+const currentConfigVersion = 1
+
+// configMigrations upgrade a config file one version at a time:
+// configMigrations[n] turns version n into version n+1.
+var configMigrations = []func(doc map[string]any){
+	// 0 -> 1: pubkeys may have been written as npubs; they are stored as
+	// hex from version 1 on.
+	func(doc map[string]any) {
+		hexPubkeys := func(node any) any {
+			switch v := node.(type) {
+			case string:
+				return npubToHex(v)
+			case []any:
+				for i, item := range v {
+					if s, ok := item.(string); ok {
+						v[i] = npubToHex(s)
+					}
+				}
+			}
+			return node
+		}
+		if v, ok := doc["admin_pubkeys"]; ok {
+			doc["admin_pubkeys"] = hexPubkeys(v)
+		}
+		if nip11, ok := doc["nip11"].(map[string]any); ok {
+			if v, ok := nip11["pubkey"]; ok {
+				nip11["pubkey"] = hexPubkeys(v)
+			}
+		}
+		if limits, ok := doc["limits"].(map[string]any); ok {
+			if v, ok := limits["rate_limit_exempt_pubkeys"]; ok {
+				limits["rate_limit_exempt_pubkeys"] = hexPubkeys(v)
+			}
+		}
+	},
+}
+
+// readConfigFile reads a config file and migrates it to
+// currentConfigVersion in memory, returning the version it was written
+// for. A missing file reads as empty.
+func readConfigFile(path string) (data []byte, version int, err error) {
+	data, err = os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, currentConfigVersion, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", path, describeJSONError(data, err))
+	}
+
+	if v, ok := doc["config_version"]; ok {
+		n, ok := v.(float64)
+		if !ok || n < 0 || n != math.Trunc(n) {
+			return nil, 0, fmt.Errorf("%s: config_version must be a whole number, got %v", path, v)
+		}
+		version = int(n)
+	}
+	if version > currentConfigVersion {
+		return nil, 0, fmt.Errorf("%s: config_version %d is newer than this relay supports (%d); upgrade tenex-relay", path, version, currentConfigVersion)
+	}
+	if version == currentConfigVersion {
+		return data, version, nil
+	}
+
+	for v := version; v < currentConfigVersion; v++ {
+		configMigrations[v](doc)
+	}
+	doc["config_version"] = currentConfigVersion
+	migrated, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to migrate %s: %w", path, err)
+	}
+	return migrated, version, nil
+}
+
+// upgradeConfigFile rewrites a config file written for an older
+// config_version in its migrated form, keeping the original next to it, and
+// returns the backup's path. A file the migrations leave unchanged apart
+// from config_version is not touched, so its layout survives; backup is
+// then empty.
+func upgradeConfigFile(path string) (backup string, err error) {
+	migrated, version, err := readConfigFile(path)
+	if err != nil || version == currentConfigVersion {
+		return "", err
+	}
+
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(original, &doc); err != nil {
+		return "", err
+	}
+	doc["config_version"] = currentConfigVersion
+	unchanged, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil || bytes.Equal(unchanged, migrated) {
+		return "", err
+	}
+
+	backup = fmt.Sprintf("%s.v%d.bak", path, version)
+	if err := os.Rename(path, backup); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, migrated, 0644); err != nil {
+		os.Rename(backup, path)
+		return "", err
+	}
+	return backup, nil
+}
+
+// describeJSONError adds the line and column to JSON syntax errors.
+func describeJSONError(data []byte, err error) error {
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return err
+	}
+	before := data[:min(int(syntax.Offset), len(data))]
+	line := strings.Count(string(before), "\n") + 1
+	col := len(before) - strings.LastIndex(string(before), "\n")
+	return fmt.Errorf("invalid JSON at line %d, column %d: %w", line, col, err)
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownConfigKeys lists the keys in a config file that don't map to any
+// Config field, each with a suggestion when a known key is spelled alike.
+func unknownConfigKeys(data []byte) []string {
+	var doc any
+	if json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+	var unknown []string
+	var walk func(t reflect.Type, node any, path string)
+	walk = func(t reflect.Type, node any, path string) {
+		if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+			return
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			obj, ok := node.(map[string]any)
+			if !ok {
+				return
+			}
+			fields := make(map[string]reflect.Type)
+			var names []string
+			for i := 0; i < t.NumField(); i++ {
+				name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+				if name != "" && name != "-" {
+					fields[name] = t.Field(i).Type
+					names = append(names, name)
+				}
+			}
+			for key, value := range obj {
+				ft, ok := fields[key]
+				if !ok {
+					// encoding/json matches field names case-insensitively
+					if i := slices.IndexFunc(names, func(n string) bool { return strings.EqualFold(n, key) }); i >= 0 {
+						ft, ok = fields[names[i]], true
+					}
+				}
+				if !ok {
+					entry := joinConfigPath(path, key)
+					if guess := closestName(key, names); guess != "" {
+						entry += fmt.Sprintf(" (did you mean %s?)", joinConfigPath(path, guess))
+					}
+					unknown = append(unknown, entry)
+					continue
+				}
+				walk(ft, value, joinConfigPath(path, key))
+			}
+		case reflect.Slice, reflect.Array:
+			list, _ := node.([]any)
+			for i, item := range list {
+				walk(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case reflect.Map:
+			obj, _ := node.(map[string]any)
+			for key, value := range obj {
+				walk(t.Elem(), value, joinConfigPath(path, key))
+			}
+		}
+	}
+	walk(reflect.TypeOf(Config{}), doc, "")
+	slices.Sort(unknown)
+	return unknown
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// closestName returns the candidate within two edits of name, if any.
+func closestName(name string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// runCheckConfig validates a config file and its overrides without
+// starting the relay or touching the file, printing every problem found.
+func runCheckConfig(path string, sets []string, args []string) error {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "Treat unknown keys as errors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path = expandPath(path)
+	data, version, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		fmt.Printf("%s does not exist; the defaults would be used\n", path)
+	}
+	if version < currentConfigVersion {
+		fmt.Printf("%s: config_version %d will be migrated to %d on the next start\n", path, version, currentConfigVersion)
+	}
+
+	problems := 0
+	for _, key := range unknownConfigKeys(data) {
+		if *strict {
+			fmt.Printf("error: unknown key %s\n", key)
+			problems++
+		} else {
+			fmt.Printf("warning: unknown key %s is ignored\n", key)
+		}
+	}
+
+	if _, _, err := buildConfig(data, sets); err != nil {
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			for _, e := range joined.Unwrap() {
+				fmt.Printf("error: %v\n", e)
+				problems++
+			}
+		} else {
+			fmt.Printf("error: %v\n", err)
+			problems++
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%s has %d problem(s)", path, problems)
+	}
+	fmt.Printf("%s is valid\n", path)
+	return nil
+}
+
+// logUnknownConfigKeys warns about keys in a config file that are ignored.
+func logUnknownConfigKeys(path string, data []byte) {
+	for _, key := range unknownConfigKeys(data) {
+		log.Printf("Ignoring unknown config key %s in %s", key, path)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	config := DefaultConfig()
+	config.Sync.Relays = []string{"wss://relay.example", "https://relay.example", "relay.example"}
+	config.Sync.Kinds = []int{1, 70000}
+	config.AdminPubkeys = []string{"not-a-pubkey"}
+	config.Limits.MaxContentLength = -1
+	config.Limits.MaxMessageLength = 1000
+	config.NIP11.Icon = "icon.png"
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, want := range []string{
+		"sync.relays[1]",
+		"sync.relays[2]",
+		"sync.kinds[1]: 70000",
+		"admin_pubkeys[0]",
+		"limits.max_content_length must be greater than 0",
+		"nip11.icon",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "sync.relays[0]") {
+		t.Errorf("expected wss://relay.example to be accepted:\n%v", err)
+	}
+
+	config = DefaultConfig()
+	config.Limits.MaxContentLength = config.Limits.MaxMessageLength + 1
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "cannot exceed limits.max_message_length") {
+		t.Fatalf("expected content length above message length to fail, got %v", err)
+	}
+
+	// Builds without cgo can't open LMDB or SQLite, so they refuse them up
+	// front rather than when the store is opened.
+	config = DefaultConfig()
+	config.Storage.Backend = storageSQLite
+	if err := config.Validate(); (err == nil) != cgoStorage {
+		t.Fatalf("expected sqlite to be accepted only with cgo (cgo=%v), got %v", cgoStorage, err)
+	}
+}
+
+func TestLoadConfigMigratesUnversionedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.json")
+	admin := strings.Repeat("ab", 32)
+	npub, err := nip19.EncodePublicKey(admin)
+	if err != nil {
+		t.Fatalf("failed to encode npub: %v", err)
+	}
+	original := `{"port": 7000, "admin_pubkeys": ["` + npub + `"], "limits": {"max_filter": 5}}`
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if !slices.Equal(config.AdminPubkeys, []string{admin}) || config.ConfigVersion != currentConfigVersion {
+		t.Fatalf("expected a hex admin pubkey at version %d, got %v at %d", currentConfigVersion, config.AdminPubkeys, config.ConfigVersion)
+	}
+
+	// Loading never writes; only the relay's start path upgrades the file.
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Fatalf("expected loading to leave the file alone, got %s", data)
+	}
+	backupPath, err := upgradeConfigFile(path)
+	if err != nil || backupPath != path+".v0.bak" {
+		t.Fatalf("expected the file to be upgraded, got %q (%v)", backupPath, err)
+	}
+	backup, err := os.ReadFile(backupPath)
+	if err != nil || string(backup) != original {
+		t.Fatalf("expected the original file to be kept, got %q (%v)", backup, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read migrated config: %v", err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("migrated config is not JSON: %v", err)
+	}
+	if doc["config_version"] != float64(currentConfigVersion) || doc["admin_pubkeys"].([]any)[0] != admin {
+		t.Fatalf("expected the file to be rewritten, got %s", data)
+	}
+
+	if unknown := unknownConfigKeys(data); !slices.Equal(unknown, []string{"limits.max_filter (did you mean limits.max_filters?)"}) {
+		t.Fatalf("unexpected unknown keys %v", unknown)
+	}
+
+	// Nothing to migrate: the hand layout stays.
+	plain := "{\n  \"port\": 7000\n}\n"
+	if err := os.WriteFile(path, []byte(plain), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if backup, err := upgradeConfigFile(path); err != nil || backup != "" {
+		t.Fatalf("expected a file without npubs to be left alone, got %q (%v)", backup, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != plain {
+		t.Fatalf("expected the file to be untouched, got %s", data)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"config_version": 99}`), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "newer than this relay supports") {
+		t.Fatalf("expected a newer config_version to be rejected, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("{\n  \"port\": 7000,\n}"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expected the syntax error's position, got %v", err)
+	}
+}
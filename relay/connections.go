@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// trackedSub is an open REQ on a tracked connection.
+type trackedSub struct {
+	id       string
+	filters  []nostr.Filter
+	openedAt time.Time
+	// delivered counts stored and live events sent for the REQ.
+	delivered int64
+	// lastLive is the last live event counted, since khatru broadcasts an
+	// event once per matching filter.
+	lastLive string
+	// closed is set once an admin closes the REQ; see closeSubscription.
+	closed bool
+}
+
+// OverwriteResponseEvent counts stored events sent for a REQ. It leaves
+// the event alone.
+func (t *connTracker) OverwriteResponseEvent(ctx context.Context, event *nostr.Event) {
+	ws := khatru.GetConnection(ctx)
+	if ws == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c := t.conns[ws]; c != nil {
+		if sub := c.subs[ctx.Done()]; sub != nil {
+			sub.delivered++
+		}
+	}
+}
+
+// PreventBroadcast counts live events sent for each REQ, and holds back
+// events that only REQs closed by an admin match. It must run after every
+// other PreventBroadcast hook so it only counts what is sent.
+func (t *connTracker) PreventBroadcast(ws *khatru.WebSocket, event *nostr.Event) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.conns[ws]
+	if c == nil {
+		return false
+	}
+	open, closed := false, false
+	for _, sub := range c.subs {
+		if !sub.matches(event) {
+			continue
+		}
+		if sub.closed {
+			closed = true
+			continue
+		}
+		open = true
+		if sub.lastLive != event.ID {
+			sub.lastLive = event.ID
+			sub.delivered++
+		}
+	}
+	return closed && !open
+}
+
+func (s *trackedSub) matches(event *nostr.Event) bool {
+	for _, f := range s.filters {
+		if f.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeSubscription sends CLOSED for a REQ and stops counting it. khatru
+// has no way to drop a REQ it didn't receive a CLOSE for, so events still
+// reach it while another open REQ on the connection matches them; events
+// only it matches are held back by PreventBroadcast. It returns the REQ's
+// context key, or false if there is no such open REQ.
+func (t *connTracker) closeSubscription(connID uint64, subID, reason string) (<-chan struct{}, bool) {
+	t.mu.Lock()
+	var (
+		ws  *khatru.WebSocket
+		key <-chan struct{}
+	)
+	for w, c := range t.conns {
+		if c.id != connID {
+			continue
+		}
+		for k, sub := range c.subs {
+			if sub.id == subID && !sub.closed {
+				sub.closed = true
+				ws, key = w, k
+			}
+		}
+	}
+	t.mu.Unlock()
+	if ws == nil {
+		return nil, false
+	}
+	ws.WriteJSON(nostr.ClosedEnvelope{SubscriptionID: subID, Reason: reason})
+	return key, true
+}
+
+// connection returns the WebSocket with the given ID.
+func (t *connTracker) connection(id uint64) *khatru.WebSocket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ws, c := range t.conns {
+		if c.id == id {
+			return ws
+		}
+	}
+	return nil
+}
+
+// connInfo describes an open WebSocket connection for the admin API.
+type connInfo struct {
+	ID            uint64    `json:"id"`
+	IP            string    `json:"ip"`
+	Pubkey        string    `json:"pubkey,omitempty"`
+	Listener      string    `json:"listener"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	BytesIn       int64     `json:"bytes_in"`
+	BytesOut      int64     `json:"bytes_out"`
+	Subscriptions []subInfo `json:"subscriptions"`
+}
+
+type subInfo struct {
+	ID        string         `json:"id"`
+	Filters   []nostr.Filter `json:"filters"`
+	OpenedAt  time.Time      `json:"opened_at"`
+	Delivered int64          `json:"events_delivered"`
+}
+
+// list returns the open connections, oldest first.
+func (t *connTracker) list() []connInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]connInfo, 0, len(t.conns))
+	for ws, c := range t.conns {
+		info := connInfo{
+			ID:            c.id,
+			IP:            c.ip,
+			Pubkey:        ws.AuthedPublicKey,
+			Listener:      c.listener,
+			ConnectedAt:   c.connectedAt,
+			Subscriptions: make([]subInfo, 0, len(c.subs)),
+		}
+		info.BytesIn, info.BytesOut = connBytes(c.conn)
+		for _, sub := range c.subs {
+			if sub.closed {
+				continue
+			}
+			info.Subscriptions = append(info.Subscriptions, subInfo{
+				ID:        sub.id,
+				Filters:   sub.filters,
+				OpenedAt:  sub.openedAt,
+				Delivered: sub.delivered,
+			})
+		}
+		sort.Slice(info.Subscriptions, func(i, j int) bool { return info.Subscriptions[i].OpenedAt.Before(info.Subscriptions[j].OpenedAt) })
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// countingListener counts the bytes read from and written to each
+// connection it accepts, before any TLS.
+type countingListener struct {
+	net.Listener
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: c}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	in, out atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(int64(n))
+	return n, err
+}
+
+// connBytes returns the bytes read from and written to c so far.
+func connBytes(c net.Conn) (in, out int64) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if cc, ok := c.(*countingConn); ok {
+		return cc.in.Load(), cc.out.Load()
+	}
+	return 0, 0
+}
+
+func (r *Relay) handleConnections(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"connections": r.conns.list()})
+}
+
+// handleKick closes the connection with the ID in the path.
+func (r *Relay) handleKick(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _ := strconv.ParseUint(req.PathValue("id"), 10, 64)
+	ws := r.conns.connection(id)
+	if ws == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "no such connection"})
+		return
+	}
+	r.conns.closeWhere(func(c *khatru.WebSocket) bool { return c == ws }, "error: connection closed by an admin")
+	logRelay.Info("kicked connection", "ip", khatru.GetIPFromRequest(ws.Request), "pubkey", ws.AuthedPublicKey)
+	json.NewEncoder(w).Encode(map[string]interface{}{"closed": id})
+}
+
+// handleCloseSubscription sends CLOSED for a REQ on a connection, both
+// named in the path.
+func (r *Relay) handleCloseSubscription(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, _ := strconv.ParseUint(req.PathValue("id"), 10, 64)
+	subID := req.PathValue("sub")
+	key, ok := r.conns.closeSubscription(id, subID, "error: subscription closed by an admin")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "no such subscription"})
+		return
+	}
+	// The client won't send CLOSE for it, so stop counting it now.
+	r.subscriptions.release(key)
+	logRelay.Info("closed subscription", "sub_id", subID, "connection", id)
+	json.NewEncoder(w).Encode(map[string]interface{}{"closed": subID})
+}
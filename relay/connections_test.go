@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestConnectionIntrospection(t *testing.T) {
+	relay, url := startTestRelay(t, nil)
+	client := dialTestRelay(t, url)
+
+	sk := nostr.GeneratePrivateKey()
+	event := func(content string) nostr.Event {
+		evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: content}
+		evt.Sign(sk)
+		return evt
+	}
+	nextEvent := func() {
+		t.Helper()
+		if _, ok := nextForSubscription(t, client, "feed").(*nostr.EventEnvelope); !ok {
+			t.Fatal("expected an EVENT for the subscription")
+		}
+	}
+	if ok, reason := publishTestRelay(t, client, event("stored")); !ok {
+		t.Fatalf("failed to publish: %s", reason)
+	}
+
+	if err := client.write(&nostr.ReqEnvelope{SubscriptionID: "feed", Filters: nostr.Filters{{Kinds: []int{1}}}}); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	nextEvent()
+	if _, ok := nextForSubscription(t, client, "feed").(*nostr.EOSEEnvelope); !ok {
+		t.Fatal("expected EOSE after the stored event")
+	}
+	// The live EVENT can arrive ahead of the OK, so don't wait on the OK.
+	if err := client.write(&nostr.EventEnvelope{Event: event("live")}); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	nextEvent()
+
+	list := relay.conns.list()
+	if len(list) != 1 || len(list[0].Subscriptions) != 1 {
+		t.Fatalf("expected one connection with one subscription, got %+v", list)
+	}
+	conn, s := list[0], list[0].Subscriptions[0]
+	if conn.BytesIn == 0 || conn.BytesOut == 0 {
+		t.Fatalf("expected bytes to be counted, got in=%d out=%d", conn.BytesIn, conn.BytesOut)
+	}
+	if len(s.Filters) != 1 || len(s.Filters[0].Kinds) != 1 || s.Filters[0].Kinds[0] != 1 || s.Delivered != 2 {
+		t.Fatalf("expected the kind 1 filter with a stored and a live event sent, got %+v", s)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.SetPathValue("id", fmt.Sprint(conn.ID))
+	req.SetPathValue("sub", s.ID)
+	rec := httptest.NewRecorder()
+	relay.handleCloseSubscription(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the subscription to close, got %d %s", rec.Code, rec.Body)
+	}
+	closed, ok := nextForSubscription(t, client, "feed").(*nostr.ClosedEnvelope)
+	if !ok || !strings.Contains(closed.Reason, "closed by an admin") {
+		t.Fatalf("expected CLOSED for the subscription, got %v", closed)
+	}
+	if subs := relay.conns.list()[0].Subscriptions; len(subs) != 0 {
+		t.Fatalf("expected the closed subscription to be gone, got %+v", subs)
+	}
+
+	rec = httptest.NewRecorder()
+	relay.handleKick(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the connection to be kicked, got %d %s", rec.Code, rec.Body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := client.read(deadline); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("expected the client to be disconnected")
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore"
+	evbadger "github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The dashboard is a single static page. It holds no data itself: its
+// script calls the admin API with the local admin token the user pastes in,
+// or with NIP-98 events signed through a NIP-07 browser extension.
+//
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+func (r *Relay) handleDashboard(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Write(dashboardHTML)
+}
+
+func (r *Relay) handleACL(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"entries": r.acl.Entries()})
+}
+
+func (r *Relay) handleKinds(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	counts, err := countEventsByKind(req.Context(), r.db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	type kindCount struct {
+		Kind   int   `json:"kind"`
+		Events int64 `json:"events"`
+	}
+	kinds := make([]kindCount, 0, len(counts))
+	var total int64
+	for kind, n := range counts {
+		kinds = append(kinds, kindCount{Kind: kind, Events: n})
+		total += n
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Kind < kinds[j].Kind })
+	json.NewEncoder(w).Encode(map[string]interface{}{"kinds": kinds, "total": total})
+}
+
+// countEventsByKind counts stored events per kind. Badger's kind index is
+// walked without reading any event; other backends are scanned.
+func countEventsByKind(ctx context.Context, store eventstore.Store) (map[int]int64, error) {
+	counts := make(map[int]int64)
+	if db, ok := store.(*evbadger.BadgerBackend); ok {
+		err := db.View(func(txn *badger.Txn) error {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{badgerKindPrefix}})
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				key := it.Item().Key()
+				if len(key) != badgerKindKeyLength {
+					continue
+				}
+				counts[int(binary.BigEndian.Uint16(key[1:3]))]++
+			}
+			return nil
+		})
+		return counts, err
+	}
+
+	err := scanEvents(ctx, store, nostr.Filter{}, func(evt *nostr.Event) error {
+		counts[evt.Kind]++
+		return nil
+	})
+	return counts, err
+}
+
+func (r *Relay) handleSyncStatus(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	syncer := r.syncer
+	r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if syncer == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "the syncer has not started yet"})
+		return
+	}
+	stats := syncer.Stats()
+	stats["relays"] = r.live.Load().Sync.Relays
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleResync clears sync cursors and restarts the workers, for one relay
+// with a body of {"relay": "wss://..."} or for all of them without a body.
+func (r *Relay) handleResync(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	syncer := r.syncer
+	r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if syncer == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "the syncer has not started yet"})
+		return
+	}
+
+	var body struct {
+		Relay string `json:"relay"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "expected a JSON body like {\"relay\": \"wss://relay.example\"}"})
+		return
+	}
+	relays, err := syncer.Resync(body.Relay)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"resyncing": relays})
+}
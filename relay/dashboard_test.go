@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestBanRejectsAndPersists(t *testing.T) {
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Storage.Backend = storageMemory
+	config.Sync.Relays = nil
+
+	relay, err := NewRelay(config)
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	defer relay.db.Close()
+
+	pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	npub, _ := nip19.EncodePublicKey(pubkey)
+	rec := httptest.NewRecorder()
+	relay.handleBans(rec, httptest.NewRequest(http.MethodPost, "/admin/bans", strings.NewReader(fmt.Sprintf(`{"pubkey":%q,"reason":"spam"}`, npub))))
+	if rec.Code != http.StatusOK || !relay.bans.IsBanned(pubkey) {
+		t.Fatalf("expected the npub to be banned, got %d %s", rec.Code, rec.Body)
+	}
+
+	rejected := func() string {
+		evt := &nostr.Event{ID: strings.Repeat("1", 64), Kind: 1, PubKey: pubkey}
+		for _, fn := range relay.khatru.RejectEvent {
+			if reject, msg := fn(context.Background(), evt); reject {
+				return msg
+			}
+		}
+		return ""
+	}
+	if msg := rejected(); msg != "blocked: pubkey is banned" {
+		t.Fatalf("expected the banned author's EVENT to be rejected, got %q", msg)
+	}
+	if recent := relay.rejections.Recent(); len(recent) != 1 || recent[0].Pubkey != pubkey || recent[0].Reason != "blocked: pubkey is banned" {
+		t.Fatalf("expected the rejection to be recorded, got %+v", recent)
+	}
+
+	reloaded, err := loadBanList(config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to reload bans: %v", err)
+	}
+	if list := reloaded.List(); len(list) != 1 || list[0].Pubkey != pubkey || list[0].Reason != "spam" {
+		t.Fatalf("expected the ban to persist, got %+v", list)
+	}
+
+	unban := func() int {
+		req := httptest.NewRequest(http.MethodDelete, "/admin/bans/"+pubkey, nil)
+		req.SetPathValue("pubkey", pubkey)
+		rec := httptest.NewRecorder()
+		relay.handleUnban(rec, req)
+		return rec.Code
+	}
+	if code := unban(); code != http.StatusOK || rejected() != "" {
+		t.Fatalf("expected the unbanned author's EVENT to be accepted, got %d", code)
+	}
+	if code := unban(); code != http.StatusNotFound {
+		t.Fatalf("expected unbanning twice to 404, got %d", code)
+	}
+}
+
+func TestACLEntrySources(t *testing.T) {
+	store, err := openStore(StorageConfig{Backend: storageMemory}, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	admin, agent, backend := strings.Repeat("a", 64), strings.Repeat("c", 64), strings.Repeat("d", 64)
+	acl := NewACL([]string{admin}, store)
+	acl.whitelistFilePath = ""
+	acl.fileAllow = map[string]bool{agent: true}
+	acl.ProcessWhitelistEvent(&nostr.Event{ID: strings.Repeat("e", 64), Kind: 14199, PubKey: backend, Tags: nostr.Tags{{"p", agent}}})
+
+	entries := make(map[string]aclEntry)
+	for _, e := range acl.Entries() {
+		entries[e.Pubkey] = e
+	}
+	if got := entries[admin].Sources; len(got) != 1 || got[0] != "admin" {
+		t.Fatalf("expected the admin to come from admin_pubkeys, got %v", got)
+	}
+	if got := entries[agent]; strings.Join(got.Sources, ",") != "whitelist_file,14199" || got.GrantedBy != backend || got.EventID != strings.Repeat("e", 64) {
+		t.Fatalf("expected the agent to come from the file and the 14199, got %+v", got)
+	}
+	if got := entries[backend]; len(got.Sources) != 1 || got.GrantedBy != backend {
+		t.Fatalf("expected the 14199 author to be self-granted, got %+v", got)
+	}
+}
+
+func TestCountEventsByKind(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{storageMemory, storageBadger} {
+		t.Run(backend, func(t *testing.T) {
+			store, err := openStore(StorageConfig{Backend: backend}, filepath.Join(t.TempDir(), "db"))
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+			defer store.Close()
+
+			for i, kind := range []int{1, 1, 1, 7, 30023} {
+				evt := &nostr.Event{
+					ID:        fmt.Sprintf("%02x%062d", i, 0),
+					PubKey:    fmt.Sprintf("%064x", 1),
+					CreatedAt: nostr.Timestamp(1700000000 + i),
+					Kind:      kind,
+					Tags:      nostr.Tags{{"d", fmt.Sprint(i)}},
+				}
+				if err := store.SaveEvent(ctx, evt); err != nil {
+					t.Fatalf("failed to save event: %v", err)
+				}
+			}
+
+			counts, err := countEventsByKind(ctx, store)
+			if err != nil {
+				t.Fatalf("failed to count: %v", err)
+			}
+			if counts[1] != 3 || counts[7] != 1 || counts[30023] != 1 || len(counts) != 3 {
+				t.Fatalf("unexpected counts %v", counts)
+			}
+		})
+	}
+}
+
+func TestDashboardEndpointsNeedAdmin(t *testing.T) {
+	relay, _ := startTestRelay(t, func(config *Config) {
+		config.Policy = ListenerPolicy{}
+	})
+	config := relay.config
+
+	get := func(path, token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d%s", config.Port, path), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("/admin/", ""); code != http.StatusOK {
+		t.Fatalf("expected the dashboard page to load without auth, got %d", code)
+	}
+	token, err := readAdminToken(config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to read admin token: %v", err)
+	}
+	for _, path := range []string{"/admin/connections", "/admin/acl", "/admin/rejections", "/admin/kinds", "/admin/sync", "/admin/bans", "/stats"} {
+		if code := get(path, ""); code != http.StatusUnauthorized {
+			t.Fatalf("expected %s to need admin auth, got %d", path, code)
+		}
+		if code := get(path, token); code != http.StatusOK {
+			t.Fatalf("expected %s to answer the admin token, got %d", path, code)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore"
+	evbadger "github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// pruneLowWatermark is the fraction of the cap that pruning aims for, so a
+// store hovering at the cap isn't pruned on every check.
+const pruneLowWatermark = 0.9
+
+// diskManager reclaims Badger space and enforces the disk usage cap.
+// Badger never shrinks its files by itself: deleted data stays in the value
+// log until GC rewrites it, and in the LSM tree until compaction drops it.
+type diskManager struct {
+	config    DiskConfig
+	dataDir   string
+	storePath string
+	db        eventstore.Store
+	badger    *evbadger.BadgerBackend // nil for other backends
+
+	// OnPrune is called for every event removed by pruning.
+	OnPrune func(context.Context, *nostr.Event)
+
+	mu              sync.Mutex // serializes GC, compaction and pruning
+	usageAfterPrune int64
+	wg              sync.WaitGroup
+
+	statsMu      sync.Mutex
+	lastGC       time.Time
+	lastPrune    time.Time
+	prunedEvents int64
+}
+
+// DiskStats is the disk section of /stats.
+type DiskStats struct {
+	StoreBytes   int64  `json:"store_bytes"`
+	DataDirBytes int64  `json:"data_dir_bytes"`
+	MaxBytes     int64  `json:"max_bytes,omitempty"`
+	LastGC       string `json:"last_gc,omitempty"`
+	LastPrune    string `json:"last_prune,omitempty"`
+	PrunedEvents int64  `json:"pruned_events"`
+}
+
+func newDiskManager(config *Config, db eventstore.Store) *diskManager {
+	d := &diskManager{
+		config:    config.Disk,
+		dataDir:   config.DataDir,
+		storePath: config.Storage.resolvedPath(config.DataDir),
+		db:        db,
+	}
+	d.badger, _ = db.(*evbadger.BadgerBackend)
+	return d
+}
+
+func (d *diskManager) maxBytes() int64 {
+	return int64(d.config.MaxSizeMB) * 1024 * 1024
+}
+
+// Start runs value-log GC and the size check every GC interval until ctx is
+// canceled. Only Badger needs either.
+func (d *diskManager) Start(ctx context.Context) {
+	if d.badger == nil {
+		return
+	}
+	interval := time.Duration(d.config.GCIntervalMinutes) * time.Minute
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.maintain(ctx)
+			}
+		}
+	}()
+	if d.config.MaxSizeMB > 0 {
+		logDisk.Info("scheduled maintenance", "gc_interval", interval, "max_size_mb", d.config.MaxSizeMB, "prune_kinds", formatKinds(d.config.PruneKinds))
+	} else {
+		logDisk.Info("scheduled maintenance", "gc_interval", interval)
+	}
+}
+
+// Wait blocks until the loop started by Start has returned, after its
+// context is canceled.
+func (d *diskManager) Wait() {
+	d.wg.Wait()
+}
+
+func (d *diskManager) maintain(ctx context.Context) {
+	if d.config.MaxSizeMB > 0 {
+		if err := d.EnforceCap(ctx); err != nil && ctx.Err() == nil {
+			logDisk.Error("pruning failed", "err", err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rewrites, err := runValueLogGC(d.badger.DB)
+	if err != nil {
+		logDisk.Warn("value-log GC failed", "err", err)
+		return
+	}
+	d.recordGC()
+	if rewrites > 0 {
+		logDisk.Info("value-log GC rewrote files", "files", rewrites)
+	}
+}
+
+// Compact forces a full LSM compaction followed by value-log GC, returning
+// the store size before and after.
+func (d *diskManager) Compact() (before, after int64, err error) {
+	if d.badger == nil {
+		return 0, 0, fmt.Errorf("compaction requires the badger backend")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	before = diskUsage(d.storePath)
+	if err := compactBadger(d.badger.DB); err != nil {
+		return before, 0, err
+	}
+	d.recordGC()
+	after = diskUsage(d.storePath)
+	return before, after, nil
+}
+
+// EnforceCap prunes events of the configured kinds, oldest first, when the
+// store is over the cap. Badger only hands space back after compaction, so
+// the amount to delete is estimated from event sizes and the store is
+// compacted afterwards.
+func (d *diskManager) EnforceCap(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	usage := diskUsage(d.storePath)
+	if usage <= d.maxBytes() {
+		d.usageAfterPrune = 0
+		return nil
+	}
+	excess := usage - int64(float64(d.maxBytes())*pruneLowWatermark)
+
+	// Space freed by the last prune may not have been handed back yet (it
+	// sits in the memtable or uncompacted levels); only prune the growth
+	// since then so the same overage isn't paid for twice.
+	if d.usageAfterPrune > 0 {
+		if usage <= d.usageAfterPrune {
+			return nil
+		}
+		excess = min(excess, usage-d.usageAfterPrune)
+	}
+	logDisk.Warn("store over the cap, pruning", "store_mb", usage>>20, "max_size_mb", d.config.MaxSizeMB, "excess_mb", excess>>20)
+
+	var total int
+	for _, kind := range d.config.PruneKinds {
+		if excess <= 0 {
+			break
+		}
+		n, freed, err := d.pruneKind(ctx, kind, excess)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			logDisk.Info("pruned events", "kind", kind, "events", n, "freed_kb", freed>>10)
+		}
+		total += n
+		excess -= freed
+	}
+
+	d.statsMu.Lock()
+	d.lastPrune = time.Now()
+	d.prunedEvents += int64(total)
+	d.statsMu.Unlock()
+	if excess > 0 {
+		logDisk.Warn("prunable kinds exhausted, store remains over the cap", "excess_mb", excess>>20)
+	}
+
+	if total > 0 && d.badger != nil {
+		if err := compactBadger(d.badger.DB); err != nil {
+			return err
+		}
+		d.recordGC()
+	}
+	d.usageAfterPrune = diskUsage(d.storePath)
+	logDisk.Info("pruning done", "store_mb", d.usageAfterPrune>>20)
+	return nil
+}
+
+// pruneKind deletes the oldest events of kind until about want bytes are
+// freed. A first pass finds the created_at cutoff; the second deletes
+// everything at or before it, newest page first.
+func (d *diskManager) pruneKind(ctx context.Context, kind int, want int64) (int, int64, error) {
+	type entry struct {
+		createdAt nostr.Timestamp
+		size      int64
+	}
+	var entries []entry
+	err := scanEvents(ctx, d.db, nostr.Filter{Kinds: []int{kind}}, func(evt *nostr.Event) error {
+		entries = append(entries, entry{evt.CreatedAt, eventSize(evt)})
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(entries) == 0 {
+		return 0, 0, nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].createdAt < entries[j].createdAt })
+	var cutoff nostr.Timestamp
+	var planned int64
+	for _, e := range entries {
+		cutoff = e.createdAt
+		planned += e.size
+		if planned >= want {
+			break
+		}
+	}
+
+	deleted := 0
+	var freed int64
+	gone := make(map[string]bool)
+	for {
+		ch, err := d.db.QueryEvents(ctx, nostr.Filter{Kinds: []int{kind}, Until: &cutoff, Limit: scanPageSize})
+		if err != nil {
+			return deleted, freed, err
+		}
+		var page []*nostr.Event
+		for evt := range ch {
+			if !gone[evt.ID] {
+				page = append(page, evt)
+			}
+		}
+		if len(page) == 0 {
+			return deleted, freed, nil
+		}
+		for _, evt := range page {
+			if err := d.db.DeleteEvent(ctx, evt); err != nil {
+				return deleted, freed, fmt.Errorf("failed to delete %s: %w", evt.ID, err)
+			}
+			if d.OnPrune != nil {
+				d.OnPrune(ctx, evt)
+			}
+			gone[evt.ID] = true
+			deleted++
+			freed += eventSize(evt)
+		}
+	}
+}
+
+func (d *diskManager) recordGC() {
+	d.statsMu.Lock()
+	d.lastGC = time.Now()
+	d.statsMu.Unlock()
+}
+
+// eventSize approximates the bytes an event takes in the store.
+func eventSize(evt *nostr.Event) int64 {
+	size := int64(32 + 32 + 64 + 8 + len(evt.Content))
+	for _, tag := range evt.Tags {
+		for _, item := range tag {
+			size += int64(len(item)) + 2
+		}
+	}
+	return size
+}
+
+// Stats reports current disk usage.
+func (d *diskManager) Stats() DiskStats {
+	d.statsMu.Lock()
+	stats := DiskStats{
+		MaxBytes:     d.maxBytes(),
+		PrunedEvents: d.prunedEvents,
+	}
+	if !d.lastGC.IsZero() {
+		stats.LastGC = d.lastGC.UTC().Format(time.RFC3339)
+	}
+	if !d.lastPrune.IsZero() {
+		stats.LastPrune = d.lastPrune.UTC().Format(time.RFC3339)
+	}
+	d.statsMu.Unlock()
+
+	stats.StoreBytes = diskUsage(d.storePath)
+	stats.DataDirBytes = diskUsage(d.dataDir)
+	return stats
+}
+
+// diskUsage sums the space allocated to the files under path. Logical sizes
+// would overstate Badger, which preallocates sparse value-log files.
+func diskUsage(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				total += allocatedSize(info)
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// runValueLogGC rewrites value-log files until Badger finds none worth
+// rewriting, returning how many were rewritten.
+func runValueLogGC(db *badger.DB) (int, error) {
+	rewrites := 0
+	for {
+		err := db.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			return rewrites, nil
+		}
+		if err != nil {
+			return rewrites, err
+		}
+		rewrites++
+	}
+}
+
+// compactBadger flattens the LSM tree, dropping deleted keys, then runs
+// value-log GC.
+func compactBadger(db *badger.DB) error {
+	if err := db.Flatten(2); err != nil {
+		return fmt.Errorf("compaction failed: %w", err)
+	}
+	if _, err := runValueLogGC(db); err != nil {
+		return fmt.Errorf("value-log GC failed: %w", err)
+	}
+	return nil
+}
+
+func (r *Relay) handleStats(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	startTime := r.startTime
+	syncer := r.syncer
+	r.mu.RUnlock()
+
+	stats := map[string]interface{}{
+		"uptime_seconds": int64(time.Since(startTime).Seconds()),
+		"storage":        r.config.Storage.Backend,
+		"disk":           r.disk.Stats(),
+	}
+	if syncer != nil {
+		stats["sync"] = syncer.Stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (r *Relay) handleCompact(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.disk.badger == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": fmt.Sprintf("compaction requires the badger backend (configured: %s)", r.config.Storage.Backend),
+		})
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	before, after, err := r.disk.Compact()
+	if err != nil {
+		logDisk.Error("admin compaction failed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	logDisk.Info("compacted store", "bytes_before", before, "bytes_after", after)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"before_bytes": before,
+		"after_bytes":  after,
+	})
+}
+
+// runCompact reclaims space in the Badger store. While the relay is running
+// it holds the storage lock, so the compaction runs through its admin API.
+// Usage: tenex-relay compact
+func runCompact(config *Config) error {
+	if config.Storage.Backend != storageBadger {
+		return fmt.Errorf("compact requires the badger backend (configured: %s)", config.Storage.Backend)
+	}
+
+	var before, after int64
+	if relayRunning(config) {
+		log.Printf("Relay is running; compacting through %s", localRelayURL(config))
+		req, err := newAdminRequest(config, http.MethodPost, "/admin/compact")
+		if err != nil {
+			return fmt.Errorf("relay is running but its admin token is unavailable: %w", err)
+		}
+		resp, err := localHTTPClient(config, 0).Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		var result struct {
+			Before int64  `json:"before_bytes"`
+			After  int64  `json:"after_bytes"`
+			Error  string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("relay returned %s", resp.Status)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("relay returned %s: %s", resp.Status, result.Error)
+		}
+		before, after = result.Before, result.After
+	} else {
+		db, err := openBadger(config.Storage.resolvedPath(config.DataDir))
+		if err != nil {
+			return fmt.Errorf("failed to open badger storage: %w", err)
+		}
+		defer db.Close()
+
+		before, after, err = newDiskManager(config, db).Compact()
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Compaction complete: %d MB → %d MB", before>>20, after>>20)
+	return nil
+}
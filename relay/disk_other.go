@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// allocatedSize falls back to the logical size where block counts aren't
+// available.
+func allocatedSize(info fs.FileInfo) int64 {
+	return info.Size()
+}
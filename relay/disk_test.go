@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestEnforceCapPrunesOldestLowValueEventsFirst(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Disk.MaxSizeMB = 1
+	config.Disk.PruneKinds = []int{7}
+
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer db.Close()
+
+	// ~2.4 MB of reactions and a few notes that must survive.
+	content := strings.Repeat("x", 60000)
+	for i := 0; i < 40; i++ {
+		kind := 7
+		if i%10 == 0 {
+			kind = 1
+		}
+		evt := &nostr.Event{
+			ID:        strings.Repeat(fmt.Sprintf("%02d", i), 32),
+			PubKey:    strings.Repeat("a", 64),
+			CreatedAt: nostr.Timestamp(1700000000 + i),
+			Kind:      kind,
+			Tags:      nostr.Tags{},
+			Content:   content,
+			Sig:       strings.Repeat("c", 128),
+		}
+		if err := db.SaveEvent(ctx, evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+	}
+
+	disk := newDiskManager(config, db)
+	if usage := diskUsage(disk.storePath); usage <= disk.maxBytes() {
+		t.Fatalf("expected the store to start over the cap, usage %d", usage)
+	}
+	if err := disk.EnforceCap(ctx); err != nil {
+		t.Fatalf("enforce cap failed: %v", err)
+	}
+
+	stats := disk.Stats()
+	if stats.PrunedEvents == 0 {
+		t.Fatal("expected some events to be pruned")
+	}
+
+	var notes, reactions int
+	oldestReaction := nostr.Timestamp(0)
+	err = scanEvents(ctx, db, nostr.Filter{}, func(evt *nostr.Event) error {
+		switch evt.Kind {
+		case 1:
+			notes++
+		case 7:
+			reactions++
+			if oldestReaction == 0 || evt.CreatedAt < oldestReaction {
+				oldestReaction = evt.CreatedAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if notes != 4 {
+		t.Fatalf("expected all 4 notes to survive pruning, got %d", notes)
+	}
+	if reactions == 0 || reactions+int(stats.PrunedEvents) != 36 {
+		t.Fatalf("expected some of the 36 reactions to survive, %d left after pruning %d", reactions, stats.PrunedEvents)
+	}
+
+	// The survivors are exactly the reactions from the oldest survivor on.
+	newer := 0
+	for i := 0; i < 40; i++ {
+		if i%10 != 0 && nostr.Timestamp(1700000000+i) >= oldestReaction {
+			newer++
+		}
+	}
+	if newer != reactions {
+		t.Fatalf("expected the oldest reactions to be pruned first: %d survivors but %d reactions since %d", reactions, newer, oldestReaction)
+	}
+}
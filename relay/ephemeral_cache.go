@@ -9,8 +9,6 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 )
 
-const ephemeralEventRetention = time.Minute
-
 type ephemeralEventCache struct {
 	mu      sync.Mutex
 	ttl     time.Duration
@@ -30,6 +28,13 @@ func newEphemeralEventCache(ttl time.Duration) *ephemeralEventCache {
 	}
 }
 
+// setTTL changes how long ephemeral events are kept, e.g. on config reload.
+func (c *ephemeralEventCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
 func (c *ephemeralEventCache) Store(ctx context.Context, event *nostr.Event) {
 	if c == nil || event == nil || !nostr.IsEphemeralKind(event.Kind) {
 		return
@@ -108,6 +113,9 @@ func (c *ephemeralEventCache) pruneLocked(now time.Time) {
 }
 
 func filterCanMatchEphemeral(filter nostr.Filter) bool {
+	if filter.Search != "" {
+		return false
+	}
 	if len(filter.Kinds) == 0 {
 		return true
 	}
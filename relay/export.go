@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// exportOptions selects which stored events an export includes.
+type exportOptions struct {
+	Kinds   []int
+	Authors []string
+	Since   *nostr.Timestamp
+	Until   *nostr.Timestamp
+}
+
+// parseExportOptions parses the comma-separated kinds/authors lists and unix
+// since/until bounds shared by the CLI flags and the admin endpoint.
+func parseExportOptions(kinds, authors, since, until string) (exportOptions, error) {
+	var opts exportOptions
+
+	for _, k := range splitList(kinds) {
+		kind, err := strconv.Atoi(k)
+		if err != nil {
+			return opts, fmt.Errorf("invalid kind %q", k)
+		}
+		opts.Kinds = append(opts.Kinds, kind)
+	}
+
+	for _, pk := range splitList(authors) {
+		if !nostr.IsValidPublicKey(pk) {
+			return opts, fmt.Errorf("invalid author pubkey %q", pk)
+		}
+		opts.Authors = append(opts.Authors, pk)
+	}
+
+	var err error
+	if opts.Since, err = parseTimestamp(since); err != nil {
+		return opts, fmt.Errorf("invalid since: %w", err)
+	}
+	if opts.Until, err = parseTimestamp(until); err != nil {
+		return opts, fmt.Errorf("invalid until: %w", err)
+	}
+
+	return opts, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseTimestamp(value string) (*nostr.Timestamp, error) {
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	ts := nostr.Timestamp(n)
+	return &ts, nil
+}
+
+func (o exportOptions) filter() nostr.Filter {
+	return nostr.Filter{
+		Kinds:   o.Kinds,
+		Authors: o.Authors,
+		Since:   o.Since,
+		Until:   o.Until,
+	}
+}
+
+func (o exportOptions) values() url.Values {
+	v := url.Values{}
+	if len(o.Kinds) > 0 {
+		kinds := make([]string, len(o.Kinds))
+		for i, k := range o.Kinds {
+			kinds[i] = strconv.Itoa(k)
+		}
+		v.Set("kinds", strings.Join(kinds, ","))
+	}
+	if len(o.Authors) > 0 {
+		v.Set("authors", strings.Join(o.Authors, ","))
+	}
+	if o.Since != nil {
+		v.Set("since", strconv.FormatInt(int64(*o.Since), 10))
+	}
+	if o.Until != nil {
+		v.Set("until", strconv.FormatInt(int64(*o.Until), 10))
+	}
+	return v
+}
+
+// exportEvents writes every matching event as one JSON object per line, the
+// format runMigrate imports.
+func exportEvents(ctx context.Context, store eventstore.Store, opts exportOptions, w io.Writer) (int, error) {
+	count := 0
+	err := scanEvents(ctx, store, opts.filter(), func(evt *nostr.Event) error {
+		line, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// handleExport streams an export from the live store. The event count, or
+// the error that cut the stream short, is sent in a trailer since the status
+// line has already gone out by then.
+func (r *Relay) handleExport(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	opts, err := parseExportOptions(q.Get("kinds"), q.Get("authors"), q.Get("since"), q.Get("until"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Large exports outlive the server's write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Trailer", "X-Export-Count, X-Export-Error")
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriterSize(w, 64*1024)
+	count, err := exportEvents(req.Context(), r.db, opts, bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	w.Header().Set("X-Export-Count", strconv.Itoa(count))
+	if err != nil {
+		w.Header().Set("X-Export-Error", err.Error())
+		logRelay.Error("export failed", "events", count, "err", err)
+		return
+	}
+	logRelay.Info("exported events", "events", count, "filter", opts.filter().String())
+}
+
+// exportFromRelay pulls an export through a running relay's admin API.
+func exportFromRelay(config *Config, opts exportOptions, w io.Writer) (int, error) {
+	req, err := newAdminRequest(config, http.MethodGet, "/admin/export?"+opts.values().Encode())
+	if err != nil {
+		return 0, fmt.Errorf("relay is running but its admin token is unavailable: %w", err)
+	}
+
+	resp, err := localHTTPClient(config, 0).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return 0, fmt.Errorf("relay returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return 0, err
+	}
+
+	// Trailers are only populated once the body has been read to EOF.
+	if msg := resp.Trailer.Get("X-Export-Error"); msg != "" {
+		return 0, fmt.Errorf("relay export failed: %s", msg)
+	}
+	count, err := strconv.Atoi(resp.Trailer.Get("X-Export-Count"))
+	if err != nil {
+		return 0, errors.New("relay export ended without a completion trailer")
+	}
+	return count, nil
+}
+
+// runExport writes stored events as JSONL (optionally gzipped) for backups
+// and moving relays. While the relay is running it holds the storage lock,
+// so the export is streamed through its admin API instead.
+// Usage: tenex-relay export [--kinds 1,30023] [--authors hex,...] [--since unix] [--until unix] [--gzip] [out.jsonl]
+func runExport(config *Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	kinds := fs.String("kinds", "", "Comma-separated kinds to export")
+	authors := fs.String("authors", "", "Comma-separated author pubkeys (hex) to export")
+	since := fs.String("since", "", "Only export events created at or after this unix timestamp")
+	until := fs.String("until", "", "Only export events created at or before this unix timestamp")
+	gzipOut := fs.Bool("gzip", false, "Gzip the output (implied by a .gz output path)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts, err := parseExportOptions(*kinds, *authors, *since, *until)
+	if err != nil {
+		return err
+	}
+
+	outputPath := fs.Arg(0)
+	var out io.Writer = os.Stdout
+	if outputPath != "" && outputPath != "-" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to create output: %w", err)
+		}
+		defer f.Close()
+		out = f
+	} else {
+		outputPath = "stdout"
+	}
+
+	bw := bufio.NewWriterSize(out, 64*1024)
+	var w io.Writer = bw
+	var gz *gzip.Writer
+	if *gzipOut || strings.HasSuffix(outputPath, ".gz") {
+		gz = gzip.NewWriter(bw)
+		w = gz
+	}
+
+	var count int
+	if relayRunning(config) {
+		log.Printf("Relay is running; exporting through %s", localRelayURL(config))
+		count, err = exportFromRelay(config, opts, w)
+	} else {
+		var db eventstore.Store
+		db, err = openStore(config.Storage, config.DataDir)
+		if err != nil {
+			return fmt.Errorf("failed to open %s storage: %w", config.Storage.Backend, err)
+		}
+		defer db.Close()
+		count, err = exportEvents(context.Background(), db, opts, w)
+	}
+	if err != nil {
+		return err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d events to %s", count, outputPath)
+	return nil
+}
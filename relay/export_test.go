@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestExportRoundTripsThroughMigrate(t *testing.T) {
+	ctx := context.Background()
+	src, err := openStore(StorageConfig{Backend: storageMemory}, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer src.Close()
+
+	author := strings.Repeat("a", 64)
+	for i, kind := range []int{1, 1, 30023} {
+		evt := &nostr.Event{
+			ID:        strings.Repeat(string("123"[i]), 64),
+			PubKey:    author,
+			CreatedAt: nostr.Timestamp(1700000000 + i),
+			Kind:      kind,
+			Tags:      nostr.Tags{},
+			Content:   "hello",
+			Sig:       strings.Repeat("c", 128),
+		}
+		if err := src.SaveEvent(ctx, evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+	}
+
+	opts, err := parseExportOptions("1", author, "1700000001", "")
+	if err != nil {
+		t.Fatalf("failed to parse options: %v", err)
+	}
+
+	var buf bytes.Buffer
+	count, err := exportEvents(ctx, src, opts, &buf)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 exported event, got %d", count)
+	}
+
+	exportPath := filepath.Join(t.TempDir(), "export.jsonl")
+	if err := os.WriteFile(exportPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	if err := runMigrate(config, []string{"--skip-verify", exportPath}); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	dst, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to open destination: %v", err)
+	}
+	defer dst.Close()
+
+	ch, err := dst.QueryEvents(ctx, nostr.Filter{IDs: []string{strings.Repeat("2", 64)}})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if evt := <-ch; evt == nil {
+		t.Fatal("expected exported event to be imported by migrate")
+	}
+}
+
+func TestExportEndpointRequiresAdminToken(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.Sync.Relays = nil
+
+	relay, err := NewRelay(cfg)
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	defer relay.db.Close()
+
+	relay.adminToken, err = writeAdminToken(cfg.DataDir)
+	if err != nil {
+		t.Fatalf("failed to write admin token: %v", err)
+	}
+	handler := relay.requireAdmin(relay.handleExport)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+
+	token, err := readAdminToken(cfg.DataDir)
+	if err != nil {
+		t.Fatalf("failed to read admin token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/admin/export?kinds=1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with token, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Result().Trailer.Get("X-Export-Count"); got != "0" {
+		t.Fatalf("expected export count trailer 0, got %q", got)
+	}
+}
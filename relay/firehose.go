@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Firehose entry types and sources.
+const (
+	firehoseAccepted = "accepted"
+	firehoseRejected = "rejected"
+
+	sourceClient  = "client"
+	sourceSync    = "sync"
+	sourceMigrate = "migrate"
+)
+
+// firehoseBuffer is how many entries a slow reader may fall behind before
+// entries are dropped for it.
+const firehoseBuffer = 1024
+
+// firehoseKeepAlive is how often an idle stream gets a comment line, so
+// proxies don't time it out.
+const firehoseKeepAlive = 15 * time.Second
+
+// firehose fans every event the relay accepts or rejects out to admin
+// readers of /admin/firehose. Publishing never blocks: with no readers it
+// does nothing, and a reader that falls behind loses entries.
+type firehose struct {
+	mu      sync.Mutex
+	readers map[*firehoseReader]struct{}
+	closed  bool
+}
+
+type firehoseEntry struct {
+	Time   time.Time    `json:"time"`
+	Type   string       `json:"type"`
+	Source string       `json:"source"`
+	Event  *nostr.Event `json:"event"`
+	// Client events only
+	IP       string `json:"ip,omitempty"`
+	Pubkey   string `json:"authed_pubkey,omitempty"`
+	Listener string `json:"listener,omitempty"`
+	// Sync events only
+	Relay string `json:"relay,omitempty"`
+
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type firehoseReader struct {
+	filter  firehoseFilter
+	entries chan firehoseEntry
+	dropped atomic.Int64
+	done    chan struct{} // closed when the firehose shuts down
+}
+
+func newFirehose() *firehose {
+	return &firehose{readers: make(map[*firehoseReader]struct{})}
+}
+
+func (f *firehose) subscribe(filter firehoseFilter) *firehoseReader {
+	reader := &firehoseReader{filter: filter, entries: make(chan firehoseEntry, firehoseBuffer), done: make(chan struct{})}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		close(reader.done)
+		return reader
+	}
+	f.readers[reader] = struct{}{}
+	return reader
+}
+
+func (f *firehose) unsubscribe(reader *firehoseReader) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.readers, reader)
+}
+
+// close ends every stream, so shutdown doesn't wait on them.
+func (f *firehose) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	for reader := range f.readers {
+		close(reader.done)
+	}
+	f.readers = nil
+}
+
+func (f *firehose) publish(entry firehoseEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.readers) == 0 {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	for reader := range f.readers {
+		if !reader.filter.matches(entry) {
+			continue
+		}
+		select {
+		case reader.entries <- entry:
+		default:
+			reader.dropped.Add(1)
+		}
+	}
+}
+
+// clientEntry fills in who sent an event over a connection.
+func clientEntry(ctx context.Context, kind string, event *nostr.Event) firehoseEntry {
+	entry := firehoseEntry{Type: kind, Source: sourceClient, Event: event, Pubkey: khatru.GetAuthed(ctx)}
+	if ws := khatru.GetConnection(ctx); ws != nil {
+		entry.IP = khatru.GetIP(ctx)
+		entry.Listener = requestListener(ws.Request)
+	}
+	return entry
+}
+
+// OnEventSaved publishes an event a client stored.
+func (f *firehose) OnEventSaved(ctx context.Context, event *nostr.Event) {
+	f.publish(clientEntry(ctx, firehoseAccepted, event))
+}
+
+// OnEphemeralEvent publishes an ephemeral event a client sent.
+func (f *firehose) OnEphemeralEvent(ctx context.Context, event *nostr.Event) {
+	entry := clientEntry(ctx, firehoseAccepted, event)
+	entry.Ephemeral = true
+	f.publish(entry)
+}
+
+func (f *firehose) rejected(ctx context.Context, event *nostr.Event, reason string) {
+	entry := clientEntry(ctx, firehoseRejected, event)
+	entry.Reason = reason
+	f.publish(entry)
+}
+
+// wrapReject publishes what reject rejects, for the checks whose
+// rejections aren't logged one by one.
+func (f *firehose) wrapReject(reject func(context.Context, *nostr.Event) (bool, string)) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		rejected, msg := reject(ctx, event)
+		if rejected {
+			f.rejected(ctx, event, msg)
+		}
+		return rejected, msg
+	}
+}
+
+// firehoseFilter selects the entries a reader asked for; the zero value
+// selects everything.
+type firehoseFilter struct {
+	filter  nostr.Filter
+	types   []string
+	sources []string
+}
+
+func (f firehoseFilter) matches(entry firehoseEntry) bool {
+	if len(f.types) > 0 && !slices.Contains(f.types, entry.Type) {
+		return false
+	}
+	if len(f.sources) > 0 && !slices.Contains(f.sources, entry.Source) {
+		return false
+	}
+	if len(f.filter.Kinds) > 0 && !slices.Contains(f.filter.Kinds, entry.Event.Kind) {
+		return false
+	}
+	if len(f.filter.Authors) > 0 && !slices.Contains(f.filter.Authors, entry.Event.PubKey) {
+		return false
+	}
+	return true
+}
+
+func parseFirehoseFilter(kinds, authors, types, sources string) (firehoseFilter, error) {
+	opts, err := parseExportOptions(kinds, authors, "", "")
+	if err != nil {
+		return firehoseFilter{}, err
+	}
+	f := firehoseFilter{filter: opts.filter(), types: splitList(types), sources: splitList(sources)}
+	for _, t := range f.types {
+		if t != firehoseAccepted && t != firehoseRejected {
+			return f, fmt.Errorf("unknown type %q: use %s or %s", t, firehoseAccepted, firehoseRejected)
+		}
+	}
+	for _, s := range f.sources {
+		if s != sourceClient && s != sourceSync && s != sourceMigrate {
+			return f, fmt.Errorf("unknown source %q: use %s, %s or %s", s, sourceClient, sourceSync, sourceMigrate)
+		}
+	}
+	return f, nil
+}
+
+// handleFirehose streams firehose entries as server-sent events, one JSON
+// entry per message. Query parameters narrow the stream: kinds and authors
+// as for /admin/export, type (accepted, rejected) and source (client, sync,
+// migrate), each comma-separated. Entries a slow reader missed are reported
+// as {"type": "dropped", "count": n}.
+func (r *Relay) handleFirehose(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	filter, err := parseFirehoseFilter(q.Get("kinds"), q.Get("authors"), q.Get("type"), q.Get("source"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	reader := r.firehose.subscribe(filter)
+	defer r.firehose.unsubscribe(reader)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": tenex-relay firehose\n\n")
+	rc.Flush()
+
+	ip := khatru.GetIPFromRequest(req)
+	logRelay.Info("firehose reader connected", "ip", ip, "filter", req.URL.RawQuery)
+	defer logRelay.Info("firehose reader disconnected", "ip", ip)
+
+	keepAlive := time.NewTicker(firehoseKeepAlive)
+	defer keepAlive.Stop()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-reader.done:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case entry := <-reader.entries:
+			if n := reader.dropped.Swap(0); n > 0 {
+				fmt.Fprintf(w, "data: {\"type\":\"dropped\",\"count\":%d}\n\n", n)
+			}
+			// Encode ends with a newline, which ends the data line.
+			fmt.Fprint(w, "data: ")
+			if err := enc.Encode(entry); err != nil {
+				return
+			}
+			if _, err := fmt.Fprint(w, "\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
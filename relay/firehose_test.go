@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestFirehose(t *testing.T) {
+	relay, url := startTestRelay(t, nil)
+	client := dialTestRelay(t, url)
+
+	token, err := readAdminToken(relay.config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to read admin token: %v", err)
+	}
+	adminRequest := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, fmt.Sprintf("http://127.0.0.1:%d%s", relay.config.Port, path), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		return resp
+	}
+
+	resp := adminRequest(http.MethodGet, "/admin/firehose?source=nowhere", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an unknown source to be refused, got %s", resp.Status)
+	}
+	stream := adminRequest(http.MethodGet, "/admin/firehose?kinds=1", "")
+	defer stream.Body.Close()
+	if stream.StatusCode != http.StatusOK || stream.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %s %s", stream.Status, stream.Header.Get("Content-Type"))
+	}
+	entries := make(chan firehoseEntry, 10)
+	go func() {
+		scanner := bufio.NewScanner(stream.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var entry firehoseEntry
+			json.Unmarshal([]byte(data), &entry)
+			entries <- entry
+		}
+	}()
+	next := func() firehoseEntry {
+		t.Helper()
+		select {
+		case entry := <-entries:
+			return entry
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a firehose entry")
+			return firehoseEntry{}
+		}
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	publish := func(kind int) bool {
+		evt := nostr.Event{Kind: kind, CreatedAt: nostr.Now(), Content: "hello"}
+		evt.Sign(sk)
+		ok, _ := publishTestRelay(t, client, evt)
+		return ok
+	}
+	// Kind 7 is filtered out of the stream.
+	if !publish(7) || !publish(1) {
+		t.Fatal("failed to publish")
+	}
+	if e := next(); e.Type != firehoseAccepted || e.Source != sourceClient || e.Event.Kind != 1 || e.IP != "127.0.0.1" {
+		t.Fatalf("expected the accepted client event, got %+v", e)
+	}
+
+	if err := relay.bans.Ban(pubkey, "spam"); err != nil {
+		t.Fatalf("failed to ban: %v", err)
+	}
+	if publish(1) {
+		t.Fatal("expected the banned author's EVENT to be rejected")
+	}
+	if e := next(); e.Type != firehoseRejected || e.Source != sourceClient || e.Reason != "blocked: pubkey is banned" {
+		t.Fatalf("expected the rejected client event, got %+v", e)
+	}
+
+	imported := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "imported"}
+	imported.Sign(nostr.GeneratePrivateKey())
+	line, _ := json.Marshal(imported)
+	resp = adminRequest(http.MethodPost, "/admin/import", string(line)+"\n")
+	var report migrateReport
+	json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || report.Imported != 1 {
+		t.Fatalf("expected the import to store one event, got %s %+v", resp.Status, report)
+	}
+	if e := next(); e.Type != firehoseAccepted || e.Source != sourceMigrate || e.Event.ID != imported.ID {
+		t.Fatalf("expected the imported event, got %+v", e)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// mainListener names port/bind_address and the Unix socket, which share
+// the top-level policy.
+const mainListener = "main"
+
+type listenerKey struct{}
+
+// withListener tags requests with the name of the listener that accepted
+// them. khatru keeps the upgrade request on the connection, so hooks can
+// look the listener's policy up later.
+func withListener(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), listenerKey{}, name)))
+	})
+}
+
+func requestListener(req *http.Request) string {
+	if req != nil {
+		if name, ok := req.Context().Value(listenerKey{}).(string); ok {
+			return name
+		}
+	}
+	return mainListener
+}
+
+// connListener returns the listener that accepted the connection behind a
+// hook's context.
+func connListener(ctx context.Context) string {
+	if ws := khatru.GetConnection(ctx); ws != nil {
+		return requestListener(ws.Request)
+	}
+	return mainListener
+}
+
+// policyFor returns the policy of the named listener.
+func (c *Config) policyFor(listener string) ListenerPolicy {
+	for _, l := range c.Listeners {
+		if l.Name == listener {
+			return l.Policy
+		}
+	}
+	return c.Policy
+}
+
+// LimitsFor returns the limits in force on a listener, with its rate limit
+// overrides applied, and the listener's policy.
+func (l *liveConfig) LimitsFor(listener string) (LimitsConfig, ListenerPolicy) {
+	config := l.Load()
+	limits, policy := config.Limits, config.policyFor(listener)
+	for _, o := range []struct {
+		override *RateLimit
+		limit    *RateLimit
+	}{
+		{policy.EventRate, &limits.EventRate},
+		{policy.EphemeralEventRate, &limits.EphemeralEventRate},
+		{policy.FilterRate, &limits.FilterRate},
+		{policy.ConnectionRate, &limits.ConnectionRate},
+	} {
+		if o.override != nil {
+			*o.limit = *o.override
+		}
+	}
+	return limits, policy
+}
+
+func (l *liveConfig) Policy(listener string) ListenerPolicy {
+	return l.Load().policyFor(listener)
+}
+
+// rejectEventByListener enforces read_only and require_auth for EVENTs.
+func rejectEventByListener(live *liveConfig) func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	return func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+		policy := live.Policy(connListener(ctx))
+		if policy.ReadOnly {
+			return true, "blocked: this relay endpoint is read-only"
+		}
+		if policy.RequireAuth && khatru.GetAuthed(ctx) == "" {
+			khatru.RequestAuth(ctx)
+			return true, "auth-required: authenticate to publish"
+		}
+		return false, ""
+	}
+}
+
+// rejectCountByListener enforces require_auth for COUNT; REQs are checked
+// alongside the relay's own auth rules.
+func rejectCountByListener(live *liveConfig) func(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	return func(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+		if live.Policy(connListener(ctx)).RequireAuth && khatru.GetAuthed(ctx) == "" {
+			khatru.RequestAuth(ctx)
+			return true, "auth-required: authenticate to count"
+		}
+		return false, ""
+	}
+}
+
+// boundListener is an open listener and the server that will serve it.
+type boundListener struct {
+	server *http.Server
+	ln     net.Listener
+}
+
+// listen opens every listener: port/bind_address, in TLS when configured,
+// the Unix socket, and the extra listeners. Each gets its own server so
+// hooks can tell which one accepted a connection.
+func (r *Relay) listen(handler http.Handler) ([]boundListener, error) {
+	var bound []boundListener
+	closeAll := func() {
+		for _, b := range bound {
+			b.ln.Close()
+		}
+	}
+
+	var tlsConfig *tls.Config
+	loadTLS := func() (*tls.Config, error) {
+		if tlsConfig != nil {
+			return tlsConfig, nil
+		}
+		cert, err := loadTLSCertificate(r.config)
+		if err != nil {
+			return nil, err
+		}
+		logRelay.Info("TLS certificate", "sha256", certFingerprint(cert.Certificate[0]))
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		return tlsConfig, nil
+	}
+
+	open := func(name, network, addr string, useTLS bool) error {
+		raw, err := net.Listen(network, addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		var ln net.Listener = countingListener{raw}
+		if network == "unix" {
+			addr = "unix:" + addr
+		}
+		server := &http.Server{
+			Handler:      withListener(name, handler),
+			ConnContext:  withNetConn,
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  120 * time.Second,
+		}
+		if useTLS {
+			if server.TLSConfig, err = loadTLS(); err != nil {
+				ln.Close()
+				return err
+			}
+			ln = tls.NewListener(ln, server.TLSConfig)
+			addr = "wss://" + addr
+		}
+		bound = append(bound, boundListener{server: server, ln: ln})
+		logRelay.Info("listening", "addr", addr, "listener", name)
+		return nil
+	}
+
+	if err := open(mainListener, "tcp", net.JoinHostPort(r.config.BindAddress, strconv.Itoa(r.config.Port)), r.config.TLS.Enabled); err != nil {
+		return nil, err
+	}
+
+	if path := r.config.UnixSocket; path != "" {
+		path = expandPath(path)
+		// A socket left behind by an unclean exit would make Listen fail.
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		if err := open(mainListener, "unix", path, false); err != nil {
+			closeAll()
+			return nil, err
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to restrict %s: %w", path, err)
+		}
+	}
+
+	for _, l := range r.config.Listeners {
+		if err := open(l.Name, "tcp", net.JoinHostPort(l.BindAddress, strconv.Itoa(l.Port)), l.TLS); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+
+	return bound, nil
+}
+
+// withUnixPeerAddr gives requests that arrive over the Unix socket a
+// loopback address: they have none, and rate limits, exemptions and logs
+// all key on the client IP.
+func withUnixPeerAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+			req.RemoteAddr = "127.0.0.1:0"
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestListenerPoliciesApplyPerListener(t *testing.T) {
+	relay, url := startTestRelay(t, func(config *Config) {
+		config.Listeners = []ListenerConfig{{
+			Name:        "lan",
+			BindAddress: "127.0.0.1",
+			Port:        freePort(t),
+			Policy:      ListenerPolicy{RequireAuth: true, ReadOnly: true},
+		}}
+	})
+
+	sk := nostr.GeneratePrivateKey()
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello"}
+	evt.Sign(sk)
+
+	trusted := dialTestRelay(t, url)
+	if reason := subscribeTestRelay(t, trusted, "a", nostr.Filter{Kinds: []int{1}}); reason != "" {
+		t.Fatalf("expected the trusted listener to serve REQs without auth, got %q", reason)
+	}
+	if ok, reason := publishTestRelay(t, trusted, evt); !ok {
+		t.Fatalf("expected the trusted listener to accept events: %s", reason)
+	}
+
+	lan := dialTestRelay(t, fmt.Sprintf("ws://127.0.0.1:%d", relay.config.Listeners[0].Port))
+	if reason := subscribeTestRelay(t, lan, "a", nostr.Filter{Kinds: []int{24133}}); !strings.HasPrefix(reason, "auth-required") {
+		t.Fatalf("expected require_auth to cover ephemeral-only REQs, got %q", reason)
+	}
+	if ok, reason := publishTestRelay(t, lan, evt); ok || !strings.Contains(reason, "read-only") {
+		t.Fatalf("expected the read-only listener to reject events, got %q", reason)
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// startTestRelay runs a relay with in-memory storage, no sync upstreams and
+// a trusted main listener on a free port, after mutate adjusts the config.
+// It returns once every listener is serving, with the main listener's
+// websocket URL, and stops the relay when the test ends.
+func startTestRelay(t *testing.T, mutate func(*Config)) (*Relay, string) {
+	t.Helper()
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Storage.Backend = storageMemory
+	config.Sync.Relays = nil
+	config.Port = freePort(t)
+	config.Policy = ListenerPolicy{Trusted: true}
+	if mutate != nil {
+		mutate(config)
+	}
+
+	relay, err := NewRelay(config)
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- relay.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("relay stopped with an error: %v", err)
+		}
+	})
+
+	// The syncer is the last thing Start sets up, after the listeners.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		relay.mu.RLock()
+		started := relay.syncer != nil
+		relay.mu.RUnlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("relay did not start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	scheme := "ws"
+	if config.TLS.Enabled {
+		scheme = "wss"
+	}
+	return relay, fmt.Sprintf("%s://127.0.0.1:%d", scheme, config.Port)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logComponents are the subsystems whose level log.components can set.
+var logComponents = []string{"relay", "acl", "sync", "search", "backup", "disk"}
+
+// Component loggers. Fields are named the same everywhere: ip, pubkey,
+// sub_id, event_id, kind, url, path and err.
+var (
+	logRelay  = componentLogger("relay")
+	logACL    = componentLogger("acl")
+	logSync   = componentLogger("sync")
+	logSearch = componentLogger("search")
+	logBackup = componentLogger("backup")
+	logDisk   = componentLogger("disk")
+)
+
+// logs routes every component logger to the output in force. Loggers are
+// created at init, before the config is read, so setupLogging and reloads
+// swap the output and levels underneath them.
+var logs = newLogRouter()
+
+type logRouter struct {
+	out    atomic.Pointer[slog.Handler]
+	levels atomic.Pointer[logLevels]
+}
+
+type logLevels struct {
+	level      slog.Level
+	components map[string]slog.Level
+}
+
+func newLogRouter() *logRouter {
+	r := &logRouter{}
+	var out slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.Level(math.MinInt)})
+	r.out.Store(&out)
+	r.levels.Store(&logLevels{level: slog.LevelInfo})
+	return r
+}
+
+func (r *logRouter) enabled(component string, level slog.Level) bool {
+	levels := r.levels.Load()
+	min, ok := levels.components[component]
+	if !ok {
+		min = levels.level
+	}
+	return level >= min
+}
+
+// setLevels applies log.level and log.components; config must be valid.
+func (r *logRouter) setLevels(config LogConfig) {
+	level, _ := parseLogLevel(config.Level)
+	components, _ := parseComponentLevels(config.Components)
+	r.levels.Store(&logLevels{level: level, components: components})
+}
+
+func componentLogger(component string) *slog.Logger {
+	return slog.New(&componentHandler{router: logs}).With("component", component)
+}
+
+// componentHandler filters records by their component's level and hands
+// the rest to the router's output. Attributes and groups are replayed onto
+// the output on every record, since it may have been swapped.
+type componentHandler struct {
+	router    *logRouter
+	component string
+	wrap      []func(slog.Handler) slog.Handler
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.router.enabled(h.component, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	out := *h.router.out.Load()
+	for _, wrap := range h.wrap {
+		out = wrap(out)
+	}
+	return out.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.wrap = append(slices.Clip(h.wrap), func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+	for _, a := range attrs {
+		if a.Key == "component" {
+			next.component = a.Value.String()
+		}
+	}
+	return &next
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	next := *h
+	next.wrap = append(slices.Clip(h.wrap), func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+	return &next
+}
+
+// setupLogging sends the relay's logs, including the standard logger's, to
+// the configured output in the configured format. Call the returned
+// function on exit to close the log file.
+func setupLogging(config LogConfig) (func(), error) {
+	var w io.Writer = os.Stderr
+	closeFile := func() {}
+	if config.File != "" {
+		f, err := openRotatingFile(expandPath(config.File), int64(config.MaxSizeMB)*1024*1024, config.MaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = f
+		closeFile = func() { f.Close() }
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.Level(math.MinInt)}
+	var out slog.Handler
+	if config.Format == logFormatJSON {
+		out = slog.NewJSONHandler(w, opts)
+	} else {
+		out = slog.NewTextHandler(w, opts)
+	}
+	logs.out.Store(&out)
+	logs.setLevels(config)
+
+	// Plain log.Printf calls become records without a component, at the
+	// default level.
+	slog.SetDefault(slog.New(&componentHandler{router: logs}))
+	return closeFile, nil
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown level %q: use debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// parseComponentLevels parses "acl=debug,sync=info".
+func parseComponentLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not component=level", part)
+		}
+		component = strings.TrimSpace(component)
+		if !slices.Contains(logComponents, component) {
+			return nil, fmt.Errorf("unknown component %q: use %s", component, strings.Join(logComponents, ", "))
+		}
+		level, err := parseLogLevel(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", component, err)
+		}
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+// rotatingFile is an append-only log file. A write that would take it past
+// maxBytes first renames it to path.1, shifting older files up to
+// path.<backups> and dropping the oldest.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	backups  int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxBytes int64, backups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			// Keep logging to whatever is open rather than losing lines.
+			fmt.Fprintf(os.Stderr, "failed to rotate %s: %v\n", f.path, err)
+		}
+	}
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.backups == 0 {
+		os.Remove(f.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.backups))
+		for i := f.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			f.open()
+			return err
+		}
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
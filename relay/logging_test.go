@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestComponentLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	router := newLogRouter()
+	var out slog.Handler = slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	router.out.Store(&out)
+	router.setLevels(LogConfig{Level: "warn", Components: "acl=debug"})
+
+	acl := slog.New(&componentHandler{router: router}).With("component", "acl")
+	sync := slog.New(&componentHandler{router: router}).With("component", "sync")
+	acl.Debug("whitelisted", "pubkey", "abc")
+	sync.Info("connected", "url", "wss://a.example")
+	sync.Warn("disconnected", "url", "wss://a.example")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected acl debug and sync warn only, got %q", lines)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("expected JSON output: %v", err)
+	}
+	if record["component"] != "acl" || record["pubkey"] != "abc" || record["level"] != "DEBUG" {
+		t.Fatalf("unexpected record %v", record)
+	}
+
+	// Swapping the levels applies to loggers created before.
+	router.setLevels(LogConfig{Level: "info"})
+	buf.Reset()
+	acl.Debug("whitelisted", "pubkey", "abc")
+	sync.Info("connected", "url", "wss://a.example")
+	if got := strings.Count(buf.String(), "\n"); got != 1 {
+		t.Fatalf("expected only the sync info line after the reload, got %q", buf.String())
+	}
+
+	if _, err := parseComponentLevels("acl=debug,nope=info"); err == nil {
+		t.Fatal("expected an unknown component to be rejected")
+	}
+	if _, err := parseComponentLevels("acl"); err == nil {
+		t.Fatal("expected a component without a level to be rejected")
+	}
+}
+
+func TestRotatingLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.log")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	for suffix, want := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		data, err := os.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path+suffix, err)
+		}
+		if string(data) != want {
+			t.Fatalf("expected %q in %s, got %q", want, path+suffix, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("expected only max_backups old files to be kept")
+	}
+}
@@ -29,29 +29,142 @@ func main() {
 	port := flag.Int("port", 0, "Override port from config")
 	genConfig := flag.Bool("gen-config", false, "Generate a default configuration file and exit")
 	showVersion := flag.Bool("version", false, "Show version and exit")
+	printConfigFlag := flag.Bool("print-config", false, "Print the effective configuration and where each value comes from, then exit")
+	var sets setFlags
+	flag.Var(&sets, "set", "Override a config value as key=value, e.g. limits.max_filters=100 (repeatable)")
 
 	flag.Parse()
 
-	// migrate subcommand: import JSONL (or legacy events.json) into BadgerDB
-	// Usage: tenex-relay migrate [/path/to/export.jsonl[.gz]]
-	if flag.NArg() > 0 && flag.Arg(0) == "migrate" {
-		config, err := LoadConfig(*configPath)
+	// loadConfig layers the config file, TENEX_RELAY_* environment
+	// variables, -set and -port; reloads re-apply the same overrides
+	loadConfig := func() (*Config, map[string]string, error) {
+		config, sources, err := loadConfigWithSources(*configPath, sets)
 		if err != nil {
-			log.Fatalf("Failed to load configuration: %v", err)
+			return nil, nil, err
 		}
 		if *port != 0 {
 			config.Port = *port
+			sources["port"] = "flag -port"
 		}
-		inputPath := ""
-		if flag.NArg() > 1 {
-			inputPath = flag.Arg(1)
+		return config, sources, nil
+	}
+
+	// check-config subcommand: report every problem in the config file and
+	// overrides without starting the relay
+	// Usage: tenex-relay check-config [--strict]
+	if flag.NArg() > 0 && flag.Arg(0) == "check-config" {
+		if *port != 0 {
+			sets = append(sets, fmt.Sprintf("port=%d", *port))
+		}
+		if err := runCheckConfig(*configPath, sets, flag.Args()[1:]); err != nil {
+			log.Fatalf("Config check failed: %v", err)
+		}
+		return
+	}
+
+	// migrate subcommand: import JSONL (or legacy events.json), a strfry export
+	// on stdin, or a remote relay's history into the configured store
+	// Usage: tenex-relay migrate [--skip-verify] [--workers n] [--restart] [--report report.json] [/path/to/export.jsonl[.gz] | - | wss://relay]
+	if flag.NArg() > 0 && flag.Arg(0) == "migrate" {
+		config, _, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
 		}
-		if err := runMigrate(config, inputPath); err != nil {
+		if err := runMigrate(config, flag.Args()[1:]); err != nil {
 			log.Fatalf("Migration failed: %v", err)
 		}
 		return
 	}
 
+	// migrate-storage subcommand: copy all events from another backend into
+	// the configured one
+	// Usage: tenex-relay migrate-storage <from-backend> [from-path]
+	if flag.NArg() > 0 && flag.Arg(0) == "migrate-storage" {
+		config, _, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		if flag.NArg() < 2 {
+			log.Fatalf("Usage: tenex-relay migrate-storage <from-backend> [from-path]")
+		}
+		fromPath := ""
+		if flag.NArg() > 2 {
+			fromPath = flag.Arg(2)
+		}
+		if err := runStorageMigrate(config, flag.Arg(1), fromPath); err != nil {
+			log.Fatalf("Storage migration failed: %v", err)
+		}
+		return
+	}
+
+	// export subcommand: write stored events as JSONL that migrate accepts
+	// Usage: tenex-relay export [--kinds 1,30023] [--authors hex,...] [--since unix] [--until unix] [--gzip] [out.jsonl]
+	if flag.NArg() > 0 && flag.Arg(0) == "export" {
+		config, _, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		if err := runExport(config, flag.Args()[1:]); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		return
+	}
+
+	// restore subcommand: validate a snapshot and swap it into the data dir
+	// Usage: tenex-relay restore <backup-file>
+	if flag.NArg() > 0 && flag.Arg(0) == "restore" {
+		config, _, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		if flag.NArg() < 2 {
+			log.Fatalf("Usage: tenex-relay restore <backup-file>")
+		}
+		if err := runRestore(config, flag.Arg(1)); err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		return
+	}
+
+	// search-reindex subcommand: rebuild the NIP-50 index from storage
+	// Usage: tenex-relay search-reindex
+	if flag.NArg() > 0 && flag.Arg(0) == "search-reindex" {
+		config, _, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		if err := runSearchReindex(config); err != nil {
+			log.Fatalf("Search reindex failed: %v", err)
+		}
+		return
+	}
+
+	// compact subcommand: force Badger compaction and value-log GC
+	// Usage: tenex-relay compact
+	if flag.NArg() > 0 && flag.Arg(0) == "compact" {
+		config, _, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		if err := runCompact(config); err != nil {
+			log.Fatalf("Compaction failed: %v", err)
+		}
+		return
+	}
+
+	// verify subcommand: check stored events and indexes, optionally repairing
+	// Usage: tenex-relay verify [--repair] [--skip-signatures] [--report report.json]
+	if flag.NArg() > 0 && flag.Arg(0) == "verify" {
+		config, _, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		if err := runVerify(config, flag.Args()[1:]); err != nil {
+			log.Fatalf("Verify failed: %v", err)
+		}
+		return
+	}
+
 	// Show version
 	if *showVersion {
 		fmt.Printf("tenex-relay %s\n", Version)
@@ -69,18 +182,32 @@ func main() {
 	}
 
 	// Load configuration
-	config, err := LoadConfig(*configPath)
+	config, sources, err := loadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	if *port != 0 {
-		config.Port = *port
+	// Print the effective configuration
+	if *printConfigFlag {
+		printConfig(config, sources)
+		os.Exit(0)
 	}
 
-	log.Printf("TENEX Relay %s starting...", Version)
-	log.Printf("Configuration loaded from %s", expandPath(*configPath))
-	log.Printf("Data directory: %s", config.DataDir)
+	closeLog, err := setupLogging(config.Log)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer closeLog()
+
+	logRelay.Info("starting", "version", Version, "config", expandPath(*configPath), "data_dir", config.DataDir)
+
+	// Only the relay itself rewrites an older config file; subcommands and
+	// -print-config leave it as it is
+	if backup, err := upgradeConfigFile(expandPath(*configPath)); err != nil {
+		logRelay.Warn("could not rewrite migrated config", "config_version", currentConfigVersion, "err", err)
+	} else if backup != "" {
+		logRelay.Info("migrated config file", "config_version", currentConfigVersion, "backup", backup)
+	}
 
 	// Create relay
 	relay, err := NewRelay(config)
@@ -88,19 +215,41 @@ func main() {
 		log.Fatalf("Failed to create relay: %v", err)
 	}
 
-	// Setup signal handling for graceful shutdown
+	// Setup signal handling for graceful shutdown; SIGHUP reloads the config
+	// and SIGUSR1/SIGUSR2 toggle read-only and maintenance mode
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	reload := func() (*Config, error) {
+		config, _, err := loadConfig()
+		return config, err
+	}
+
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range modeSignals {
+		signal.Notify(sigCh, sig)
+	}
 
 	go func() {
-		sig := <-sigCh
-		log.Printf("Received signal %v, initiating shutdown...", sig)
-		cancel()
+		for sig := range sigCh {
+			if sig == syscall.SIGHUP {
+				logRelay.Info("received SIGHUP, reloading configuration")
+				relay.ReloadConfig(reload)
+				continue
+			}
+			if mode, ok := modeSignals[sig]; ok {
+				relay.ToggleMode(mode, "signal")
+				continue
+			}
+			logRelay.Info("received signal, shutting down", "signal", sig.String())
+			cancel()
+			return
+		}
 	}()
 
+	relay.WatchConfig(ctx, *configPath, reload)
+
 	// Start relay
 	if err := relay.Start(ctx); err != nil {
 		log.Fatalf("Relay error: %v", err)
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var _ eventstore.Store = (*memoryStore)(nil)
+
+// memoryStore keeps events in a slice ordered newest first. It backs the
+// "memory" storage backend for tests and throwaway relays, so it favours
+// simplicity over query speed: every query is a linear scan. Unlike
+// eventstore's slicestore it is safe for concurrent use and snapshots query
+// results before streaming them.
+type memoryStore struct {
+	mu       sync.RWMutex
+	events   []*nostr.Event
+	ids      map[string]bool
+	maxLimit int
+}
+
+func (m *memoryStore) Init() error {
+	m.ids = make(map[string]bool)
+	if m.maxLimit == 0 {
+		m.maxLimit = storeQueryLimit
+	}
+	return nil
+}
+
+func (m *memoryStore) Close() {}
+
+func (m *memoryStore) QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+	ch := make(chan *nostr.Event)
+	if filter.LimitZero {
+		close(ch)
+		return ch, nil
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > m.maxLimit {
+		limit = m.maxLimit
+	}
+
+	m.mu.RLock()
+	var matched []*nostr.Event
+	for _, evt := range m.events {
+		if filter.Matches(evt) {
+			matched = append(matched, evt)
+			if len(matched) == limit {
+				break
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	go func() {
+		defer close(ch)
+		for _, evt := range matched {
+			select {
+			case ch <- evt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (m *memoryStore) CountEvents(ctx context.Context, filter nostr.Filter) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var n int64
+	for _, evt := range m.events {
+		if filter.Matches(evt) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memoryStore) SaveEvent(ctx context.Context, evt *nostr.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveLocked(evt)
+}
+
+func (m *memoryStore) saveLocked(evt *nostr.Event) error {
+	if m.ids[evt.ID] {
+		return eventstore.ErrDupEvent
+	}
+	idx, _ := slices.BinarySearchFunc(m.events, evt, newestFirst)
+	m.events = slices.Insert(m.events, idx, evt)
+	m.ids[evt.ID] = true
+	return nil
+}
+
+func (m *memoryStore) DeleteEvent(ctx context.Context, evt *nostr.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteLocked(evt.ID)
+	return nil
+}
+
+func (m *memoryStore) deleteLocked(id string) {
+	if !m.ids[id] {
+		return
+	}
+	m.events = slices.DeleteFunc(m.events, func(e *nostr.Event) bool { return e.ID == id })
+	delete(m.ids, id)
+}
+
+func (m *memoryStore) ReplaceEvent(ctx context.Context, evt *nostr.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	filter := nostr.Filter{Kinds: []int{evt.Kind}, Authors: []string{evt.PubKey}}
+	if nostr.IsAddressableKind(evt.Kind) {
+		filter.Tags = nostr.TagMap{"d": []string{evt.Tags.GetD()}}
+	}
+
+	var older []string
+	for _, previous := range m.events {
+		if !filter.Matches(previous) {
+			continue
+		}
+		if previous.CreatedAt > evt.CreatedAt || (previous.CreatedAt == evt.CreatedAt && previous.ID <= evt.ID) {
+			return nil // a newer (or the same) version is already stored
+		}
+		older = append(older, previous.ID)
+	}
+	for _, id := range older {
+		m.deleteLocked(id)
+	}
+	return m.saveLocked(evt)
+}
+
+// newestFirst orders events by descending created_at, then ascending ID.
+func newestFirst(a, b *nostr.Event) int {
+	if a.CreatedAt != b.CreatedAt {
+		if a.CreatedAt > b.CreatedAt {
+			return -1
+		}
+		return 1
+	}
+	switch {
+	case a.ID < b.ID:
+		return -1
+	case a.ID > b.ID:
+		return 1
+	}
+	return 0
+}
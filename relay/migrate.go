@@ -2,101 +2,681 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
-	badger "github.com/dgraph-io/badger/v4"
-	evbadger "github.com/fiatjaf/eventstore/badger"
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/khatru"
 	"github.com/nbd-wtf/go-nostr"
 )
 
-// runMigrate imports events from a JSONL file (optionally gzipped) into BadgerDB.
-// Pass the input path as the first non-flag argument after "migrate".
-// If no path is given, falls back to <data_dir>/events.json (legacy JSON array).
-func runMigrate(config *Config, inputPath string) error {
-	badgerPath := filepath.Join(config.DataDir, "badger")
+// Failure reasons reported by migrate
+const (
+	migrateDecodeError      = "decode_error"
+	migrateInvalidID        = "invalid_id"
+	migrateInvalidSignature = "invalid_signature"
+	migrateDuplicate        = "duplicate"
+	migrateStoreError       = "store_error"
+)
+
+// migrateOptions controls an import run.
+type migrateOptions struct {
+	Input      string
+	SkipVerify bool
+	Workers    int
+	BatchSize  int
+	Restart    bool
+	ReportPath string
+
+	// Relay sources only
+	Filter   exportOptions
+	PageSize int
+	AuthKey  string
+}
+
+// migrateReport counts the outcome of every record in an import.
+type migrateReport struct {
+	Source   string         `json:"source"`
+	Records  int            `json:"records"`
+	Imported int            `json:"imported"`
+	Resumed  int            `json:"resumed"`
+	Failures map[string]int `json:"failures"`
+	Duration string         `json:"duration,omitempty"`
+}
+
+func (r *migrateReport) failed() int {
+	n := 0
+	for _, c := range r.Failures {
+		n += c
+	}
+	return n
+}
+
+func (r *migrateReport) log() {
+	log.Printf("Migration complete: %d records, %d imported, %d failed/skipped, %d already imported by a previous run",
+		r.Records, r.Imported, r.failed(), r.Resumed)
+	reasons := make([]string, 0, len(r.Failures))
+	for reason := range r.Failures {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		log.Printf("  %s: %d", reason, r.Failures[reason])
+	}
+}
+
+// write saves the report as JSON to path, if one is given.
+func (r *migrateReport) write(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// migrateCheckpoint records how far an import of a given file got, so an
+// interrupted run can skip the records it already handled.
+type migrateCheckpoint struct {
+	Input   string        `json:"input"`
+	Size    int64         `json:"size"`
+	ModTime int64         `json:"mod_time"`
+	Records int           `json:"records"`
+	Cursor  int64         `json:"cursor,omitempty"` // relay sources: oldest created_at imported
+	Report  migrateReport `json:"report"`
+}
 
-	if inputPath == "" {
-		inputPath = filepath.Join(config.DataDir, "events.json")
+// runMigrate imports events into the configured storage backend from a
+// JSONL file (optionally gzipped), a JSONL stream on stdin ("-", e.g. piped
+// from `strfry export`), or a ws:// / wss:// relay URL, which is paged
+// backwards through history.
+// Pass the source as the first non-flag argument after "migrate".
+// If no source is given, falls back to <data_dir>/events.json (legacy JSON array).
+// Usage: tenex-relay migrate [--skip-verify] [--workers n] [--batch n] [--restart] [--report report.json] [/path/to/export.jsonl[.gz] | - | wss://relay]
+func runMigrate(config *Config, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	skipVerify := fs.Bool("skip-verify", false, "Skip event ID and signature verification")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of parallel verify/write workers")
+	batchSize := fs.Int("batch", 1000, "Records per batch (and checkpoint interval)")
+	restart := fs.Bool("restart", false, "Ignore any checkpoint and import from the beginning")
+	reportPath := fs.String("report", "", "Write a JSON report of the import to this path")
+	kinds := fs.String("kinds", "", "Relay sources: comma-separated kinds to fetch")
+	authors := fs.String("authors", "", "Relay sources: comma-separated author pubkeys (hex) to fetch")
+	since := fs.String("since", "", "Relay sources: only fetch events created at or after this unix timestamp")
+	until := fs.String("until", "", "Relay sources: only fetch events created at or before this unix timestamp")
+	pageSize := fs.Int("page", 500, "Relay sources: events requested per page")
+	authKey := fs.String("auth-key", "", "Relay sources: secret key (hex or nsec) for relays that require NIP-42 auth")
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
 
-	if _, err := os.Stat(inputPath); os.IsNotExist(err) {
-		return fmt.Errorf("input file not found: %s", inputPath)
+	filter, err := parseExportOptions(*kinds, *authors, *since, *until)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Migrating from %s → %s", inputPath, badgerPath)
+	opts := migrateOptions{
+		Input:      fs.Arg(0),
+		SkipVerify: *skipVerify,
+		Workers:    *workers,
+		BatchSize:  *batchSize,
+		Restart:    *restart,
+		ReportPath: *reportPath,
+		Filter:     filter,
+		PageSize:   *pageSize,
+		AuthKey:    *authKey,
+	}
+	if opts.Input == "" {
+		opts.Input = filepath.Join(config.DataDir, "events.json")
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	if opts.PageSize < 1 {
+		opts.PageSize = 1
+	}
 
-	db := &evbadger.BadgerBackend{
-		Path: badgerPath,
-		BadgerOptionsModifier: func(opts badger.Options) badger.Options {
-			return opts.WithLogger(nil)
-		},
+	switch {
+	case isRelayURL(opts.Input):
+		return migrateRelay(config, opts)
+	case relayRunning(config):
+		// The relay holds the storage lock, so import through its admin API.
+		return migrateThroughRelay(config, opts)
+	case opts.Input == "-":
+		return migrateStdin(config, opts)
+	default:
+		return migrateFile(config, opts)
 	}
-	if err := db.Init(); err != nil {
-		return fmt.Errorf("failed to open BadgerDB: %w", err)
+}
+
+func migrateFile(config *Config, opts migrateOptions) error {
+	stat, err := os.Stat(opts.Input)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("input file not found: %s", opts.Input)
+	} else if err != nil {
+		return err
+	}
+
+	db, err := openMigrateTarget(config, opts.Input)
+	if err != nil {
+		return err
 	}
 	defer db.Close()
 
-	f, err := os.Open(inputPath)
+	f, err := os.Open(opts.Input)
 	if err != nil {
 		return fmt.Errorf("failed to open input: %w", err)
 	}
 	defer f.Close()
 
-	var reader io.Reader = f
-	if strings.HasSuffix(inputPath, ".gz") {
-		gz, err := gzip.NewReader(f)
-		if err != nil {
-			return fmt.Errorf("failed to open gzip: %w", err)
+	records, err := openRecordReader(f)
+	if err != nil {
+		return err
+	}
+
+	key, err := filepath.Abs(opts.Input)
+	if err != nil {
+		key = opts.Input
+	}
+	checkpointPath := migrateCheckpointPath(config.DataDir, key)
+	checkpoint := migrateCheckpoint{
+		Input:   opts.Input,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime().Unix(),
+	}
+	if opts.Restart {
+		os.Remove(checkpointPath)
+	} else if prev, ok := loadMigrateCheckpoint(checkpointPath, checkpoint); ok {
+		checkpoint = prev
+		log.Printf("Resuming from checkpoint: %d records already handled", checkpoint.Records)
+	}
+
+	m := newMigrator(db, opts, checkpoint.Report)
+	m.onBatch = func(report migrateReport) {
+		checkpoint.Records = report.Records
+		checkpoint.Report = report
+		if err := saveMigrateCheckpoint(checkpointPath, checkpoint); err != nil {
+			log.Printf("Warning: failed to write checkpoint: %v", err)
 		}
-		defer gz.Close()
-		reader = gz
 	}
 
-	ctx := context.Background()
-	total, failed := 0, 0
-	scanner := bufio.NewScanner(reader)
-	scanner.Buffer(make([]byte, 2*1024*1024), 2*1024*1024) // 2MB per line
+	if err := m.run(context.Background(), records, checkpoint.Records); err != nil {
+		return err
+	}
+	os.Remove(checkpointPath)
 
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
+	return m.finish(config)
+}
+
+// migrateStdin imports a JSONL stream such as `strfry export` output. A
+// stream can't be re-read, so there is no checkpoint; re-running an
+// interrupted import is safe since duplicates are skipped.
+func migrateStdin(config *Config, opts migrateOptions) error {
+	db, err := openMigrateTarget(config, "stdin")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	records, err := openRecordReader(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	opts.Input = "stdin"
+	m := newMigrator(db, opts, migrateReport{})
+	if err := m.run(context.Background(), records, 0); err != nil {
+		return err
+	}
+	return m.finish(config)
+}
+
+// migrateThroughRelay posts a file or stdin to a running relay's
+// /admin/import. The relay keeps no checkpoint; re-running an interrupted
+// import is safe since duplicates are skipped.
+func migrateThroughRelay(config *Config, opts migrateOptions) error {
+	in := os.Stdin
+	if opts.Input != "-" {
+		f, err := os.Open(opts.Input)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("input file not found: %s", opts.Input)
+		} else if err != nil {
+			return fmt.Errorf("failed to open input: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	log.Printf("Relay is running; importing %s through %s", opts.Input, localRelayURL(config))
+	path := "/admin/import"
+	if opts.SkipVerify {
+		path += "?skip_verify=true"
+	}
+	req, err := newAdminRequest(config, http.MethodPost, path)
+	if err != nil {
+		return fmt.Errorf("relay is running but its admin token is unavailable: %w", err)
+	}
+	req.Body = in
+	resp, err := localHTTPClient(config, 0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		migrateReport
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("relay returned %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("relay returned %s: %s", resp.Status, result.Error)
+	}
+
+	report := result.migrateReport
+	report.Source = opts.Input
+	report.log()
+	return report.write(opts.ReportPath)
+}
+
+// handleImport imports the JSONL or JSON array body, as `tenex-relay
+// migrate` would, for a relay that holds the storage lock. Imported events
+// are indexed and published to the firehose as they are stored.
+func (r *Relay) handleImport(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if mode := r.mode.Load(); mode != modeNormal {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "relay is in " + mode + " mode"})
+		return
+	}
+	if !r.writes.begin() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": errShuttingDown.Error()})
+		return
+	}
+	defer r.writes.wg.Done()
+
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	records, err := openRecordReader(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	ctx := req.Context()
+	skipVerify, _ := strconv.ParseBool(req.URL.Query().Get("skip_verify"))
+	m := newMigrator(r.db, migrateOptions{Input: "admin import", SkipVerify: skipVerify, Workers: runtime.NumCPU(), BatchSize: 1000}, migrateReport{})
+	m.onStored = func(event *nostr.Event) {
+		r.trackStored(ctx, event)
+		r.firehose.publish(firehoseEntry{Type: firehoseAccepted, Source: sourceMigrate, Event: event, IP: khatru.GetIPFromRequest(req)})
+	}
+	if err := m.run(ctx, records, 0); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	m.report.Duration = time.Since(m.started).Round(time.Millisecond).String()
+	logRelay.Info("imported events", "records", m.report.Records, "imported", m.report.Imported, "failed", m.report.failed())
+	json.NewEncoder(w).Encode(m.report)
+}
+
+// openMigrateTarget opens the configured store for an import.
+func openMigrateTarget(config *Config, source string) (eventstore.Store, error) {
+	if config.Storage.Backend == storageMemory {
+		return nil, fmt.Errorf("cannot migrate into the memory backend: it holds no data between runs")
+	}
+
+	log.Printf("Migrating from %s → %s (%s)", source, config.Storage.resolvedPath(config.DataDir), config.Storage.Backend)
+
+	if err := config.EnsureDataDir(); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s storage: %w", config.Storage.Backend, err)
+	}
+	return db, nil
+}
+
+// migrator verifies and writes records in batches. Within a batch, records
+// are decoded and verified in parallel, then written by a pool of workers;
+// versions of the same replaceable or addressable event always go to the
+// same worker so ReplaceEvent never races with itself.
+type migrator struct {
+	db      eventstore.Store
+	opts    migrateOptions
+	report  migrateReport
+	started time.Time
+
+	// onBatch is called after each batch has been fully written.
+	onBatch func(migrateReport)
+	// onStored is called for each event written, from several workers at once.
+	onStored func(*nostr.Event)
+}
+
+// newMigrator starts from a previous run's report when resuming.
+func newMigrator(db eventstore.Store, opts migrateOptions, report migrateReport) *migrator {
+	report.Source = opts.Input
+	if report.Failures == nil {
+		report.Failures = make(map[string]int)
+	}
+	return &migrator{
+		db:      db,
+		opts:    opts,
+		started: time.Now(),
+		report:  report,
+	}
+}
+
+// run imports every record from src, skipping the first skip records (already
+// handled by an interrupted run).
+func (m *migrator) run(ctx context.Context, src recordReader, skip int) error {
+	m.report.Resumed = 0
+	seen := 0
+	batch := make([][]byte, 0, m.opts.BatchSize)
+	nextProgress := (m.report.Records/50000 + 1) * 50000
+
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read error: %w", err)
 		}
 
-		var evt nostr.Event
-		if err := json.Unmarshal(line, &evt); err != nil {
-			log.Printf("Warning: failed to decode event: %v", err)
-			failed++
+		seen++
+		if seen <= skip {
+			m.report.Resumed++
 			continue
 		}
 
+		batch = append(batch, rec)
+		if len(batch) == m.opts.BatchSize {
+			m.importBatch(ctx, batch)
+			batch = batch[:0]
+			if m.report.Records >= nextProgress {
+				log.Printf("  %d records processed, %d imported...", m.report.Records, m.report.Imported)
+				nextProgress += 50000
+			}
+		}
+	}
+
+	if len(batch) > 0 {
+		m.importBatch(ctx, batch)
+	}
+	return nil
+}
+
+func (m *migrator) importBatch(ctx context.Context, batch [][]byte) {
+	workers := m.opts.Workers
+	events := make([]*nostr.Event, len(batch))
+	reasons := make([]string, len(batch))
+
+	// Decode and verify in parallel over contiguous slices of the batch.
+	var wg sync.WaitGroup
+	chunk := (len(batch) + workers - 1) / workers
+	for start := 0; start < len(batch); start += chunk {
+		end := min(start+chunk, len(batch))
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				events[i], reasons[i] = m.decode(batch[i])
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	// Shard the writes.
+	shards := make([][]int, workers)
+	for i, evt := range events {
+		if evt == nil {
+			continue
+		}
+		shard := i % workers
 		if nostr.IsReplaceableKind(evt.Kind) || nostr.IsAddressableKind(evt.Kind) {
-			err = db.ReplaceEvent(ctx, &evt)
+			h := fnv.New32a()
+			fmt.Fprintf(h, "%d:%s:%s", evt.Kind, evt.PubKey, evt.Tags.GetD())
+			shard = int(h.Sum32() % uint32(workers))
+		}
+		shards[shard] = append(shards[shard], i)
+	}
+	for _, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(indexes []int) {
+			defer wg.Done()
+			for _, i := range indexes {
+				err := saveEvent(ctx, m.db, events[i])
+				switch {
+				case err == nil:
+					if m.onStored != nil {
+						m.onStored(events[i])
+					}
+				case errors.Is(err, eventstore.ErrDupEvent):
+					reasons[i] = migrateDuplicate
+				default:
+					reasons[i] = migrateStoreError
+					log.Printf("Warning: failed to store %s: %v", truncateForLog(events[i].ID, 12), err)
+				}
+			}
+		}(shard)
+	}
+	wg.Wait()
+
+	for _, reason := range reasons {
+		m.report.Records++
+		if reason == "" {
+			m.report.Imported++
 		} else {
-			err = db.SaveEvent(ctx, &evt)
+			m.report.Failures[reason]++
+		}
+	}
+
+	if m.onBatch != nil {
+		m.onBatch(m.report)
+	}
+}
+
+// decode parses and (unless disabled) verifies one record, returning the
+// failure reason if it can't be imported.
+func (m *migrator) decode(rec []byte) (*nostr.Event, string) {
+	evt, err := decodeRecord(rec)
+	if err != nil {
+		return nil, migrateDecodeError
+	}
+	if m.opts.SkipVerify {
+		return evt, ""
+	}
+	if !evt.CheckID() {
+		return nil, migrateInvalidID
+	}
+	if ok, _ := evt.CheckSignature(); !ok {
+		return nil, migrateInvalidSignature
+	}
+	return evt, ""
+}
+
+// finish logs and optionally writes the report. Imported events bypass the
+// search index, so it is flagged for a rebuild on the next start.
+func (m *migrator) finish(config *Config) error {
+	m.report.Duration = time.Since(m.started).Round(time.Millisecond).String()
+	m.report.log()
+
+	if m.report.Imported > 0 {
+		if err := resetSearchIndex(config.DataDir); err != nil {
+			return err
 		}
+	}
+
+	return m.report.write(m.opts.ReportPath)
+}
+
+// decodeRecord accepts a bare event object, as written by `tenex-relay
+// export`, `strfry export` or a nostr-rs-relay dump, or a relay message
+// envelope (["EVENT", {...}] or ["EVENT", "<sub>", {...}]).
+func decodeRecord(rec []byte) (*nostr.Event, error) {
+	if len(rec) > 0 && rec[0] == '[' {
+		var envelope []json.RawMessage
+		if err := json.Unmarshal(rec, &envelope); err != nil {
+			return nil, err
+		}
+		var label string
+		if len(envelope) < 2 || json.Unmarshal(envelope[0], &label) != nil || label != "EVENT" {
+			return nil, errors.New("not an EVENT envelope")
+		}
+		rec = envelope[len(envelope)-1]
+	}
+
+	var evt nostr.Event
+	if err := json.Unmarshal(rec, &evt); err != nil {
+		return nil, err
+	}
+	if evt.ID == "" || evt.PubKey == "" {
+		return nil, errors.New("missing id or pubkey")
+	}
+	return &evt, nil
+}
+
+// recordReader yields one raw JSON record at a time.
+type recordReader interface {
+	Next() ([]byte, error)
+}
+
+// openRecordReader sniffs the input: gzip is detected by its magic bytes, a
+// JSON array of events is streamed element by element, and anything else is
+// read as one record per line with no line length limit.
+func openRecordReader(r io.Reader) (recordReader, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
 		if err != nil {
-			failed++
+			return nil, fmt.Errorf("failed to open gzip: %w", err)
 		}
+		br = bufio.NewReaderSize(gz, 1<<20)
+	}
 
-		total++
-		if total%50000 == 0 {
-			log.Printf("  %d events imported...", total)
+	if isJSONArrayOfObjects(br) {
+		dec := json.NewDecoder(br)
+		if _, err := dec.Token(); err != nil {
+			return nil, err
 		}
+		return &arrayRecordReader{dec: dec}, nil
 	}
+	return &lineRecordReader{r: br}, nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("read error: %w", err)
+// isJSONArrayOfObjects peeks past leading whitespace for "[" followed by "{".
+func isJSONArrayOfObjects(br *bufio.Reader) bool {
+	head, _ := br.Peek(4096)
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if len(head) == 0 || head[0] != '[' {
+		return false
 	}
+	head = bytes.TrimLeft(head[1:], " \t\r\n")
+	return len(head) > 0 && head[0] == '{'
+}
 
-	log.Printf("Migration complete: %d imported, %d failed/skipped", total, failed)
-	return nil
+type lineRecordReader struct {
+	r *bufio.Reader
+}
+
+func (l *lineRecordReader) Next() ([]byte, error) {
+	for {
+		line, err := l.r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+type arrayRecordReader struct {
+	dec *json.Decoder
+}
+
+func (a *arrayRecordReader) Next() ([]byte, error) {
+	if !a.dec.More() {
+		return nil, io.EOF
+	}
+	var raw json.RawMessage
+	if err := a.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// migrateCheckpointPath names the checkpoint for a source: the absolute
+// path of an input file, or a relay URL and filter.
+func migrateCheckpointPath(dataDir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dataDir, "migrate-"+hex.EncodeToString(sum[:8])+".checkpoint.json")
+}
+
+// loadMigrateCheckpoint returns the saved checkpoint if it belongs to the
+// same, unchanged input file.
+func loadMigrateCheckpoint(path string, current migrateCheckpoint) (migrateCheckpoint, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return migrateCheckpoint{}, false
+	}
+	var prev migrateCheckpoint
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return migrateCheckpoint{}, false
+	}
+	if prev.Size != current.Size || prev.ModTime != current.ModTime {
+		log.Printf("Ignoring checkpoint %s: input file changed since it was written", path)
+		return migrateCheckpoint{}, false
+	}
+	return prev, true
+}
+
+func saveMigrateCheckpoint(path string, checkpoint migrateCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip42"
+)
+
+func isRelayURL(source string) bool {
+	return strings.HasPrefix(source, "ws://") || strings.HasPrefix(source, "wss://")
+}
+
+// migrateRelay imports history from a remote relay. Progress is checkpointed
+// as the oldest created_at imported, so an interrupted run picks up paging
+// from there.
+func migrateRelay(config *Config, opts migrateOptions) error {
+	secretKey, err := parseSecretKey(opts.AuthKey)
+	if err != nil {
+		return err
+	}
+
+	filter := opts.Filter.filter()
+	db, err := openMigrateTarget(config, fmt.Sprintf("%s %s", opts.Input, filter.String()))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	checkpointPath := migrateCheckpointPath(config.DataDir, opts.Input+" "+filter.String())
+	checkpoint := migrateCheckpoint{Input: opts.Input}
+	if opts.Restart {
+		os.Remove(checkpointPath)
+	} else if prev, ok := loadMigrateCheckpoint(checkpointPath, checkpoint); ok && prev.Cursor > 0 {
+		checkpoint = prev
+		until := nostr.Timestamp(checkpoint.Cursor)
+		filter.Until = &until
+		log.Printf("Resuming from checkpoint: %d records already handled, continuing before %s",
+			checkpoint.Records, until.Time().UTC().Format(time.RFC3339))
+	}
+
+	ctx := context.Background()
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	relay, err := dialRelay(connectCtx, opts.Input)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", opts.Input, err)
+	}
+	defer relay.Close()
+
+	records := &relayRecordReader{
+		ctx:       ctx,
+		relay:     relay,
+		filter:    filter,
+		pageSize:  opts.PageSize,
+		secretKey: secretKey,
+	}
+
+	m := newMigrator(db, opts, checkpoint.Report)
+	m.onBatch = func(report migrateReport) {
+		checkpoint.Records = report.Records
+		checkpoint.Cursor = int64(records.cursor)
+		checkpoint.Report = report
+		if err := saveMigrateCheckpoint(checkpointPath, checkpoint); err != nil {
+			log.Printf("Warning: failed to write checkpoint: %v", err)
+		}
+	}
+
+	if err := m.run(ctx, records, 0); err != nil {
+		return err
+	}
+	os.Remove(checkpointPath)
+
+	return m.finish(config)
+}
+
+func parseSecretKey(key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(key, "nsec") {
+		prefix, value, err := nip19.Decode(key)
+		if err != nil || prefix != "nsec" {
+			return "", errors.New("invalid auth key")
+		}
+		return value.(string), nil
+	}
+	if !nostr.IsValid32ByteHex(key) {
+		return "", errors.New("invalid auth key")
+	}
+	return key, nil
+}
+
+// relayRecordReader pages backwards through a relay's history, newest first,
+// moving the until bound to the oldest event of each page. Pages overlap at
+// that boundary second, so IDs already seen there are skipped; paging ends
+// on an empty page.
+type relayRecordReader struct {
+	ctx       context.Context
+	relay     *relayConn
+	filter    nostr.Filter
+	pageSize  int
+	secretKey string
+
+	buf      []*nostr.Event
+	boundary map[string]bool
+	cursor   nostr.Timestamp // created_at of the last event returned
+	done     bool
+	pages    int
+}
+
+func (r *relayRecordReader) Next() ([]byte, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return nil, io.EOF
+		}
+		if err := r.fetchPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	evt := r.buf[0]
+	r.buf = r.buf[1:]
+	r.cursor = evt.CreatedAt
+	return json.Marshal(evt)
+}
+
+func (r *relayRecordReader) fetchPage() error {
+	filter := r.filter
+	filter.Limit = r.pageSize
+
+	events, err := r.query(filter)
+	if err != nil {
+		return err
+	}
+	r.pages++
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].CreatedAt > events[j].CreatedAt
+	})
+
+	fresh := events[:0]
+	for _, evt := range events {
+		if !r.boundary[evt.ID] {
+			fresh = append(fresh, evt)
+		}
+	}
+	if len(fresh) == 0 {
+		if len(events) > 0 && r.filter.Until != nil {
+			// A page of already-seen events all created in the same second,
+			// full or cut short by the relay's own limit: step past it
+			// rather than loop forever. Only an empty page ends the run.
+			log.Printf("Warning: a page of events at %d was already seen; any more in that second are skipped (raise --page)", *r.filter.Until)
+			until := *r.filter.Until - 1
+			r.filter.Until = &until
+			r.boundary = nil
+			return nil
+		}
+		r.done = true
+		return nil
+	}
+
+	oldest := events[len(events)-1].CreatedAt
+	if r.boundary == nil || *r.filter.Until != oldest {
+		r.boundary = make(map[string]bool)
+	}
+	for _, evt := range events {
+		if evt.CreatedAt == oldest {
+			r.boundary[evt.ID] = true
+		}
+	}
+	r.filter.Until = &oldest
+
+	if r.pages%20 == 0 {
+		log.Printf("  fetched %d pages, now at %s", r.pages, oldest.Time().UTC().Format(time.RFC3339))
+	}
+
+	r.buf = fresh
+	return nil
+}
+
+// query runs one REQ until EOSE. If the relay closes it asking for auth and
+// a key was given, it authenticates and retries once.
+func (r *relayRecordReader) query(filter nostr.Filter) ([]*nostr.Event, error) {
+	events, reason, err := r.queryOnce(filter)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(reason, "auth-required:") && r.secretKey != "" {
+		if err := r.relay.auth(r.secretKey, time.Now().Add(10*time.Second)); err != nil {
+			return nil, fmt.Errorf("auth failed: %w", err)
+		}
+		events, reason, err = r.queryOnce(filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if reason != "" {
+		return nil, fmt.Errorf("relay closed subscription: %s", reason)
+	}
+	return events, nil
+}
+
+func (r *relayRecordReader) queryOnce(filter nostr.Filter) ([]*nostr.Event, string, error) {
+	deadline := time.Now().Add(60 * time.Second)
+	if ctxDeadline, ok := r.ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	subID := fmt.Sprintf("migrate:%d", r.pages)
+	if err := r.relay.write(&nostr.ReqEnvelope{SubscriptionID: subID, Filters: nostr.Filters{filter}}); err != nil {
+		return nil, "", fmt.Errorf("subscribe: %w", err)
+	}
+
+	var events []*nostr.Event
+	for {
+		env, err := r.relay.read(deadline)
+		if err != nil {
+			return nil, "", fmt.Errorf("waiting for EOSE: %w", err)
+		}
+		switch env := env.(type) {
+		case *nostr.EventEnvelope:
+			if env.SubscriptionID != nil && *env.SubscriptionID == subID {
+				evt := env.Event
+				events = append(events, &evt)
+			}
+		case *nostr.EOSEEnvelope:
+			if string(*env) == subID {
+				closeID := nostr.CloseEnvelope(subID)
+				r.relay.write(&closeID)
+				return events, "", nil
+			}
+		case *nostr.ClosedEnvelope:
+			if env.SubscriptionID == subID {
+				return nil, env.Reason, nil
+			}
+		}
+	}
+}
+
+// relayConn is a minimal NIP-01 client over a plain websocket. Migration only
+// ever has one REQ or AUTH in flight, so messages are read synchronously by
+// whoever is waiting on them; go-nostr's Relay, with its background reader,
+// races with itself when the connection closes.
+type relayConn struct {
+	url       string
+	ws        *websocket.Conn
+	parser    nostr.MessageParser
+	challenge string
+}
+
+func dialRelay(ctx context.Context, url string) (*relayConn, error) {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &relayConn{url: url, ws: ws, parser: nostr.NewMessageParser()}, nil
+}
+
+func (c *relayConn) write(env nostr.Envelope) error {
+	data, err := env.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// read returns the next message the relay sends before deadline, skipping
+// anything unparseable. AUTH challenges are remembered for auth and NOTICEs
+// are logged; both are still returned.
+func (c *relayConn) read(deadline time.Time) (nostr.Envelope, error) {
+	c.ws.SetReadDeadline(deadline)
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		env, err := c.parser.ParseMessage(string(data))
+		if err != nil || env == nil {
+			continue
+		}
+		switch env := env.(type) {
+		case *nostr.AuthEnvelope:
+			if env.Challenge != nil {
+				c.challenge = *env.Challenge
+			}
+		case *nostr.NoticeEnvelope:
+			log.Printf("  notice from %s: %s", c.url, string(*env))
+		}
+		return env, nil
+	}
+}
+
+// auth answers the relay's last AUTH challenge and waits for it to be
+// accepted.
+func (c *relayConn) auth(secretKey string, deadline time.Time) error {
+	if c.challenge == "" {
+		return errors.New("relay sent no challenge")
+	}
+	pubkey, err := nostr.GetPublicKey(secretKey)
+	if err != nil {
+		return err
+	}
+	evt := nip42.CreateUnsignedAuthEvent(c.challenge, pubkey, c.url)
+	if err := evt.Sign(secretKey); err != nil {
+		return err
+	}
+	if err := c.write(&nostr.AuthEnvelope{Event: evt}); err != nil {
+		return err
+	}
+	for {
+		env, err := c.read(deadline)
+		if err != nil {
+			return err
+		}
+		if ok, isOK := env.(*nostr.OKEnvelope); isOK && ok.EventID == evt.ID {
+			if !ok.OK {
+				return errors.New(ok.Reason)
+			}
+			return nil
+		}
+	}
+}
+
+func (c *relayConn) Close() error {
+	c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return c.ws.Close()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func signedEvent(t *testing.T, sk string, content string) *nostr.Event {
+	t.Helper()
+	evt := &nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      1,
+		Tags:      nostr.Tags{},
+		Content:   content,
+	}
+	if err := evt.Sign(sk); err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+	return evt
+}
+
+func readMigrateReport(t *testing.T, path string) migrateReport {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var report migrateReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	return report
+}
+
+func TestMigrateReportsFailuresByReason(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+
+	plain := signedEvent(t, sk, "plain")
+	wrapped := signedEvent(t, sk, "wrapped")
+	large := signedEvent(t, sk, "padded")
+
+	badID := *signedEvent(t, sk, "original")
+	badID.Content = "tampered"
+	badSig := *signedEvent(t, sk, "bad sig")
+	badSig.Sig = strings.Repeat("0", 128)
+
+	line := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode: %v", err)
+		}
+		return string(data)
+	}
+	input := strings.Join([]string{
+		line(plain),
+		line([]any{"EVENT", "sub", wrapped}),
+		line(plain),
+		line(badID),
+		line(badSig),
+		"{not json",
+		// longer than the old 2MB line limit
+		"{" + strings.Repeat(" ", 3*1024*1024) + line(large)[1:],
+	}, "\n") + "\n"
+
+	inputPath := filepath.Join(t.TempDir(), "export.jsonl")
+	if err := os.WriteFile(inputPath, []byte(input), 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := runMigrate(config, []string{"--workers", "3", "--batch", "2", "--report", reportPath, inputPath}); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	report := readMigrateReport(t, reportPath)
+	if report.Records != 7 || report.Imported != 3 {
+		t.Fatalf("expected 7 records and 3 imported, got %+v", report)
+	}
+	for reason, want := range map[string]int{
+		migrateDuplicate:        1,
+		migrateInvalidID:        1,
+		migrateInvalidSignature: 1,
+		migrateDecodeError:      1,
+	} {
+		if got := report.Failures[reason]; got != want {
+			t.Errorf("expected %d %s, got %d", want, reason, got)
+		}
+	}
+
+	if _, err := os.Stat(migrateCheckpointPath(config.DataDir, inputPath)); !os.IsNotExist(err) {
+		t.Fatal("expected checkpoint to be removed after a complete run")
+	}
+
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer db.Close()
+	ch, err := db.QueryEvents(context.Background(), nostr.Filter{IDs: []string{wrapped.ID, large.ID}})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	found := 0
+	for range ch {
+		found++
+	}
+	if found != 2 {
+		t.Fatalf("expected wrapped and large events to be stored, found %d", found)
+	}
+}
+
+func TestMigrateResumesFromCheckpoint(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	events := make([]*nostr.Event, 4)
+	lines := make([]string, len(events))
+	for i := range events {
+		events[i] = signedEvent(t, sk, strings.Repeat("e", i+1))
+		data, _ := json.Marshal(events[i])
+		lines[i] = string(data)
+	}
+
+	// A JSON array spread over several lines, like the legacy events.json.
+	inputPath := filepath.Join(t.TempDir(), "events.json")
+	if err := os.WriteFile(inputPath, []byte("[\n"+strings.Join(lines, ",\n")+"\n]\n"), 0644); err != nil {
+		t.Fatalf("failed to write input: %v", err)
+	}
+	stat, err := os.Stat(inputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	if err := config.EnsureDataDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend an earlier run handled the first two records.
+	checkpoint := migrateCheckpoint{
+		Input:   inputPath,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime().Unix(),
+		Records: 2,
+		Report:  migrateReport{Records: 2, Imported: 2, Failures: map[string]int{}},
+	}
+	if err := saveMigrateCheckpoint(migrateCheckpointPath(config.DataDir, inputPath), checkpoint); err != nil {
+		t.Fatalf("failed to write checkpoint: %v", err)
+	}
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := runMigrate(config, []string{"--report", reportPath, inputPath}); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	report := readMigrateReport(t, reportPath)
+	if report.Resumed != 2 || report.Records != 4 || report.Imported != 4 {
+		t.Fatalf("expected 2 resumed of 4 records, got %+v", report)
+	}
+
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer db.Close()
+	ch, err := db.QueryEvents(context.Background(), nostr.Filter{Authors: []string{events[0].PubKey}})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	stored := map[string]bool{}
+	for evt := range ch {
+		stored[evt.ID] = true
+	}
+	if len(stored) != 2 || !stored[events[2].ID] || !stored[events[3].ID] {
+		t.Fatalf("expected only the records after the checkpoint to be imported, got %d", len(stored))
+	}
+}
+
+func TestMigrateFromRelayPagesBackwards(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	remoteStore, err := openStore(StorageConfig{Backend: storageMemory}, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	// Several events share a timestamp so pages overlap at the boundary.
+	var ids []string
+	for i := 0; i < 9; i++ {
+		evt := &nostr.Event{
+			CreatedAt: nostr.Timestamp(1700000000 + i/3),
+			Kind:      1,
+			Tags:      nostr.Tags{},
+			Content:   strings.Repeat("r", i+1),
+		}
+		if err := evt.Sign(sk); err != nil {
+			t.Fatalf("failed to sign event: %v", err)
+		}
+		if err := remoteStore.SaveEvent(context.Background(), evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+		ids = append(ids, evt.ID)
+	}
+
+	remote := khatru.NewRelay()
+	remote.QueryEvents = append(remote.QueryEvents, remoteStore.QueryEvents)
+	remote.RejectFilter = append(remote.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+		if khatru.GetAuthed(ctx) == "" {
+			khatru.RequestAuth(ctx)
+			return true, "auth-required: log in first"
+		}
+		return false, ""
+	})
+	server := httptest.NewServer(remote)
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := runMigrate(config, []string{"--page", "4", "--auth-key", sk, "--report", reportPath, url}); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	report := readMigrateReport(t, reportPath)
+	if report.Imported != len(ids) {
+		t.Fatalf("expected %d imported, got %+v", len(ids), report)
+	}
+
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer db.Close()
+	ch, err := db.QueryEvents(context.Background(), nostr.Filter{IDs: ids})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	found := 0
+	for range ch {
+		found++
+	}
+	if found != len(ids) {
+		t.Fatalf("expected %d events stored, found %d", len(ids), found)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Relay modes. Read-only rejects EVENTs and pauses the syncer so the store
+// stops changing; maintenance does the same and also refuses new
+// connections, leaving open ones to finish.
+const (
+	modeNormal      = "normal"
+	modeReadOnly    = "read-only"
+	modeMaintenance = "maintenance"
+)
+
+func isKnownMode(mode string) bool {
+	return mode == modeNormal || mode == modeReadOnly || mode == modeMaintenance
+}
+
+// relayMode holds the mode in force. The config sets it at startup and on
+// reloads that change it; signals and the admin API switch it in between.
+type relayMode struct {
+	current atomic.Pointer[string]
+}
+
+func newRelayMode(mode string) *relayMode {
+	m := &relayMode{}
+	m.current.Store(&mode)
+	return m
+}
+
+func (m *relayMode) Load() string {
+	return *m.current.Load()
+}
+
+func (m *relayMode) swap(mode string) string {
+	return *m.current.Swap(&mode)
+}
+
+// RejectEvent blocks writes outside normal mode.
+func (m *relayMode) RejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	switch m.Load() {
+	case modeReadOnly:
+		return true, "blocked: relay is in read-only mode"
+	case modeMaintenance:
+		return true, "blocked: relay is under maintenance"
+	}
+	return false, ""
+}
+
+// SetMode switches the relay's mode, pausing or resuming the syncer to
+// match. source says what asked for the change, for the log.
+func (r *Relay) SetMode(mode, source string) error {
+	if !isKnownMode(mode) {
+		return fmt.Errorf("unknown mode %q: use %s, %s or %s", mode, modeNormal, modeReadOnly, modeMaintenance)
+	}
+	prev := r.mode.swap(mode)
+	if prev == mode {
+		return nil
+	}
+	logRelay.Info("mode changed", "from", prev, "to", mode, "source", source)
+
+	r.mu.RLock()
+	syncer := r.syncer
+	r.mu.RUnlock()
+	if syncer != nil {
+		syncer.SetPaused(mode != modeNormal)
+	}
+	return nil
+}
+
+// withMaintenance answers new requests with 503 in maintenance mode. Health
+// checks and the admin API stay up so the mode can be watched and lifted.
+// During shutdown only /health and /ready are answered.
+func (r *Relay) withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.shuttingDown.Load() && req.URL.Path != "/health" && req.URL.Path != "/ready" {
+			http.Error(w, shutdownReason, http.StatusServiceUnavailable)
+			return
+		}
+		if r.mode.Load() == modeMaintenance && req.URL.Path != "/health" && req.URL.Path != "/ready" && !strings.HasPrefix(req.URL.Path, "/admin/") {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "relay is under maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// handleMode reports the mode on GET and switches it on POST, with a body
+// of {"mode": "read-only"}.
+func (r *Relay) handleMode(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method == http.MethodPost {
+		var body struct {
+			Mode string `json:"mode"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "expected a JSON body like {\"mode\": \"read-only\"}"})
+			return
+		}
+		if err := r.SetMode(body.Mode, "admin API"); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"mode": r.mode.Load()})
+}
+
+// ToggleMode switches between normal and mode, for signals.
+func (r *Relay) ToggleMode(mode, source string) {
+	next := mode
+	if r.mode.Load() == mode {
+		next = modeNormal
+	}
+	r.SetMode(next, source)
+}
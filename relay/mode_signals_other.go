@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// modeSignals is empty where SIGUSR1 and SIGUSR2 don't exist; use the
+// config or the admin API instead.
+var modeSignals = map[os.Signal]string{}
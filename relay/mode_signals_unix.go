@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// modeSignals toggle the relay's mode: SIGUSR1 between normal and
+// read-only, SIGUSR2 between normal and maintenance.
+var modeSignals = map[os.Signal]string{
+	syscall.SIGUSR1: modeReadOnly,
+	syscall.SIGUSR2: modeMaintenance,
+}
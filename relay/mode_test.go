@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRelayModes(t *testing.T) {
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Storage.Backend = storageMemory
+	config.Sync.Relays = nil
+
+	relay, err := NewRelay(config)
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	defer relay.db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	syncer := NewSyncer(config.Sync, relay.db)
+	syncer.Start(ctx)
+	defer syncer.Stop()
+	relay.syncer = syncer
+
+	rejected := func() string {
+		evt := &nostr.Event{Kind: 1, PubKey: strings.Repeat("b", 64)}
+		for _, fn := range relay.khatru.RejectEvent {
+			if reject, msg := fn(ctx, evt); reject {
+				return msg
+			}
+		}
+		return ""
+	}
+	status := func(handler http.Handler, path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	handler := relay.withMaintenance(ok)
+
+	rec := httptest.NewRecorder()
+	relay.handleMode(rec, httptest.NewRequest(http.MethodPost, "/admin/mode", strings.NewReader(`{"mode":"read-only"}`)))
+	if rec.Code != http.StatusOK || relay.mode.Load() != modeReadOnly {
+		t.Fatalf("expected the admin API to switch to read-only, got %d %s", rec.Code, rec.Body)
+	}
+	if msg := rejected(); !strings.HasPrefix(msg, "blocked:") {
+		t.Fatalf("expected EVENTs to be blocked in read-only mode, got %q", msg)
+	}
+	if paused, _ := syncer.Stats()["paused"].(bool); !paused {
+		t.Fatal("expected the syncer to pause in read-only mode")
+	}
+	if status(handler, "/") != http.StatusOK {
+		t.Fatal("expected read-only mode to keep accepting connections")
+	}
+
+	relay.ToggleMode(modeMaintenance, "test")
+	if status(handler, "/") != http.StatusServiceUnavailable || status(handler, "/health") != http.StatusOK {
+		t.Fatal("expected maintenance mode to refuse new connections but keep /health up")
+	}
+
+	next := *config
+	next.Mode = modeNormal
+	relay.Reload(&next)
+	if relay.mode.Load() != modeMaintenance {
+		t.Fatal("expected a reload that leaves mode unchanged to keep the switched mode")
+	}
+	next.Mode = modeReadOnly
+	relay.Reload(&next)
+	relay.ToggleMode(modeReadOnly, "test")
+	if relay.mode.Load() != modeNormal || rejected() != "" {
+		t.Fatalf("expected normal mode to accept EVENTs again, got %s", relay.mode.Load())
+	}
+	if paused, _ := syncer.Stats()["paused"].(bool); paused {
+		t.Fatal("expected the syncer to resume in normal mode")
+	}
+
+	if err := relay.SetMode("off", "test"); err == nil {
+		t.Fatal("expected an unknown mode to be rejected")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// relayInformation is the NIP-11 document served: khatru's Info with the
+// live config applied, plus the fields Info's types have no room for.
+type relayInformation struct {
+	nip11.RelayInformationDocument
+	PrivacyPolicy string            `json:"privacy_policy,omitempty"`
+	Retention     []RetentionConfig `json:"retention,omitempty"`
+	Limitation    *relayLimits      `json:"limitation,omitempty"`
+}
+
+// relayLimits adds the default query limit and the rate limits, which
+// aren't part of NIP-11 yet, to the limitation document.
+type relayLimits struct {
+	nip11.RelayLimitationDocument
+	DefaultLimit       int        `json:"default_limit"`
+	EventRate          *RateLimit `json:"event_rate,omitempty"`
+	EphemeralEventRate *RateLimit `json:"ephemeral_event_rate,omitempty"`
+}
+
+// relayLimitation describes the limits NewRelay enforces. The auth and
+// write flags depend on the listener and mode; nip11Overrides sets them.
+func relayLimitation(config *Config) *nip11.RelayLimitationDocument {
+	return &nip11.RelayLimitationDocument{
+		MaxMessageLength: config.Limits.MaxMessageLength,
+		MaxSubscriptions: config.Limits.MaxSubscriptions,
+		MaxFilters:       config.Limits.MaxFilters,
+		MaxLimit:         config.Limits.MaxQueryLimit,
+		MaxEventTags:     config.Limits.MaxEventTags,
+		MaxContentLength: config.Limits.MaxContentLength,
+	}
+}
+
+// nip11Overrides applies the live config and the policy of the listener
+// the request came in on to the document built from khatru's Info, so
+// reloads and mode changes take effect without touching Info.
+func (r *Relay) nip11Overrides(req *http.Request, info *relayInformation) {
+	config := r.live.Load()
+	rates, policy := r.live.LimitsFor(requestListener(req))
+
+	info.Name = config.NIP11.Name
+	info.Description = config.NIP11.Description
+	info.PubKey = config.NIP11.Pubkey
+	info.Contact = config.NIP11.Contact
+	info.Software = config.NIP11.Software
+	info.Version = config.NIP11.Version
+	info.Icon = config.NIP11.Icon
+	info.Banner = config.NIP11.Banner
+	info.PrivacyPolicy = config.NIP11.PrivacyPolicy
+
+	// Keep the NIPs khatru and NewRelay add on top of the configured ones.
+	nips := make([]any, 0, len(config.NIP11.SupportedNIPs))
+	for _, nip := range config.NIP11.SupportedNIPs {
+		nips = append(nips, nip)
+	}
+	for _, n := range info.SupportedNIPs {
+		if nip, ok := n.(int); ok && !slices.Contains(r.config.NIP11.SupportedNIPs, nip) && !slices.Contains(config.NIP11.SupportedNIPs, nip) {
+			nips = append(nips, nip)
+		}
+	}
+	info.SupportedNIPs = nips
+
+	info.Retention = append([]RetentionConfig(nil), config.NIP11.Retention...)
+	info.Retention = append(info.Retention, RetentionConfig{
+		Kinds: []KindRange{{From: 20000, To: 29999}},
+		Time:  int64(config.Limits.EphemeralRetentionSeconds),
+	})
+
+	limits := &relayLimits{
+		RelayLimitationDocument: *relayLimitation(config),
+		DefaultLimit:            config.Limits.DefaultQueryLimit,
+	}
+	// Subscriptions to stored events need NIP-42 everywhere but on a
+	// trusted listener.
+	limits.AuthRequired = !policy.Trusted
+	limits.RestrictedWrites = policy.ReadOnly || r.mode.Load() != modeNormal || r.quota != nil || len(r.bans.List()) > 0
+	// The listener's own rates, which NIP-11 clients can't otherwise see.
+	// A trusted listener isn't rate limited at all.
+	if rates.EventRate.PerSecond > 0 && !policy.Trusted {
+		limits.EventRate = &rates.EventRate
+	}
+	if rates.EphemeralEventRate.PerSecond > 0 && !policy.Trusted {
+		limits.EphemeralEventRate = &rates.EphemeralEventRate
+	}
+	info.Limitation = limits
+}
+
+// nip11Document builds the relay information document for a request the
+// way khatru's HandleNIP11 would, then applies nip11Overrides.
+func (r *Relay) nip11Document(req *http.Request) *relayInformation {
+	info := &relayInformation{RelayInformationDocument: *r.khatru.Info}
+	info.SupportedNIPs = slices.Clone(info.SupportedNIPs)
+	if len(r.khatru.DeleteEvent) > 0 {
+		info.AddSupportedNIP(9)
+	}
+	if len(r.khatru.CountEvents) > 0 {
+		info.AddSupportedNIP(45)
+	}
+	if r.khatru.Negentropy {
+		info.AddSupportedNIP(77)
+	}
+
+	r.nip11Overrides(req, info)
+	return info
+}
+
+// withNIP11Extras answers NIP-11 requests with nip11Document and passes
+// everything else on to next.
+func (r *Relay) withNIP11Extras(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Upgrade") == "websocket" || req.Header.Get("Accept") != "application/nostr+json" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/nostr+json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(r.nip11Document(req))
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNIP11DocumentPublishesLimitsAndRetention(t *testing.T) {
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Storage.Backend = storageMemory
+	config.NIP11.PrivacyPolicy = "https://example.com/privacy"
+	config.NIP11.Retention = []RetentionConfig{
+		{Kinds: []KindRange{{From: 7, To: 7}, {From: 30000, To: 39999}}, Count: 1000},
+	}
+
+	relay, err := NewRelay(config)
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	defer relay.db.Close()
+
+	fetch := func(listener string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/nostr+json")
+		rec := httptest.NewRecorder()
+		withListener(listener, relay.withNIP11Extras(relay.khatru)).ServeHTTP(rec, req)
+		return rec
+	}
+	rec := fetch(mainListener)
+
+	var info struct {
+		PrivacyPolicy string `json:"privacy_policy"`
+		Limitation    struct {
+			MaxMessageLength int       `json:"max_message_length"`
+			MaxLimit         int       `json:"max_limit"`
+			DefaultLimit     int       `json:"default_limit"`
+			AuthRequired     bool      `json:"auth_required"`
+			RestrictedWrites bool      `json:"restricted_writes"`
+			EventRate        RateLimit `json:"event_rate"`
+		} `json:"limitation"`
+		Retention []struct {
+			Kinds []any `json:"kinds"`
+			Time  int64 `json:"time"`
+			Count int   `json:"count"`
+		} `json:"retention"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("failed to decode NIP-11 document %q: %v", rec.Body.String(), err)
+	}
+
+	if info.PrivacyPolicy != config.NIP11.PrivacyPolicy {
+		t.Fatalf("unexpected privacy_policy %q", info.PrivacyPolicy)
+	}
+	l := info.Limitation
+	if l.MaxMessageLength != config.Limits.MaxMessageLength || l.MaxLimit != config.Limits.MaxQueryLimit ||
+		l.DefaultLimit != config.Limits.DefaultQueryLimit || !l.AuthRequired || l.RestrictedWrites {
+		t.Fatalf("unexpected limitation %+v", l)
+	}
+	if l.EventRate != config.Limits.EventRate {
+		t.Fatalf("unexpected event_rate %+v", l.EventRate)
+	}
+
+	if len(info.Retention) != 2 {
+		t.Fatalf("expected configured and ephemeral retention entries, got %+v", info.Retention)
+	}
+	if got, _ := json.Marshal(info.Retention[0].Kinds); string(got) != "[7,[30000,39999]]" || info.Retention[0].Count != 1000 {
+		t.Fatalf("unexpected retention entry %s count=%d", got, info.Retention[0].Count)
+	}
+	if info.Retention[1].Time != int64(config.Limits.EphemeralRetentionSeconds) {
+		t.Fatalf("unexpected ephemeral retention %+v", info.Retention[1])
+	}
+
+	// The flags follow the listener's policy and the mode.
+	config.Policy = ListenerPolicy{Trusted: true}
+	lanRate := RateLimit{PerSecond: 1, Burst: 3}
+	config.Listeners = []ListenerConfig{{Name: "lan", Port: 1, Policy: ListenerPolicy{ReadOnly: true, EventRate: &lanRate}}}
+	relay.live.store(config)
+	decode := func(listener string) {
+		t.Helper()
+		info.Limitation.AuthRequired, info.Limitation.RestrictedWrites = false, false
+		info.Limitation.EventRate = RateLimit{}
+		if err := json.Unmarshal(fetch(listener).Body.Bytes(), &info); err != nil {
+			t.Fatalf("failed to decode NIP-11 document: %v", err)
+		}
+	}
+	decode(mainListener)
+	if info.Limitation.AuthRequired || info.Limitation.RestrictedWrites || info.Limitation.EventRate != (RateLimit{}) {
+		t.Fatalf("expected a trusted listener to need no auth and have no rate limit, got %+v", info.Limitation)
+	}
+	decode("lan")
+	if !info.Limitation.AuthRequired || !info.Limitation.RestrictedWrites {
+		t.Fatalf("expected a read-only listener to restrict writes, got %+v", info.Limitation)
+	}
+	if info.Limitation.EventRate != lanRate {
+		t.Fatalf("expected the listener's own event_rate, got %+v", info.Limitation.EventRate)
+	}
+	relay.SetMode(modeReadOnly, "test")
+	decode(mainListener)
+	if !info.Limitation.RestrictedWrites {
+		t.Fatalf("expected read-only mode to restrict writes, got %+v", info.Limitation)
+	}
+	relay.SetMode(modeNormal, "test")
+	relay.bans.Ban(strings.Repeat("ab", 32), "spam")
+	decode(mainListener)
+	if !info.Limitation.RestrictedWrites {
+		t.Fatalf("expected bans to restrict writes, got %+v", info.Limitation)
+	}
+}
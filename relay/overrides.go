@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// envPrefix starts the environment variable for every config key:
+// limits.max_filters is read from TENEX_RELAY_LIMITS_MAX_FILTERS.
+const envPrefix = "TENEX_RELAY_"
+
+// Value sources reported by -print-config.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+)
+
+// configField is one settable leaf of Config, addressed by its JSON path.
+type configField struct {
+	key   string
+	index []int
+}
+
+// configFields lists every leaf of Config in declaration order. Nested
+// structs are flattened; slices and maps are single leaves.
+func configFields() []configField {
+	var fields []configField
+	var walk func(t reflect.Type, prefix string, index []int)
+	walk = func(t reflect.Type, prefix string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			idx := append(append([]int(nil), index...), i)
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type, prefix+name+".", idx)
+				continue
+			}
+			fields = append(fields, configField{key: prefix + name, index: idx})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", nil)
+	return fields
+}
+
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// setConfigValue parses value into the field named by key. Strings are
+// taken as-is; lists of strings or numbers may be comma-separated; anything
+// else is JSON, e.g. quota.kinds={"4201":{"max_events":10}}.
+func setConfigValue(config *Config, key, value string) error {
+	var field *configField
+	for _, f := range configFields() {
+		if f.key == key {
+			field = &f
+			break
+		}
+	}
+	if field == nil {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+
+	v := reflect.ValueOf(config).Elem().FieldByIndex(field.index)
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+		return nil
+	case v.Kind() == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		var items []string
+		if value != "" {
+			items = strings.Split(value, ",")
+		}
+		elem := v.Type().Elem()
+		list := reflect.MakeSlice(v.Type(), 0, len(items))
+		for _, item := range items {
+			item = strings.TrimSpace(item)
+			ptr := reflect.New(elem)
+			if elem.Kind() == reflect.String {
+				ptr.Elem().SetString(item)
+			} else if err := json.Unmarshal([]byte(item), ptr.Interface()); err != nil {
+				return fmt.Errorf("invalid value for %s: %q", key, item)
+			}
+			list = reflect.Append(list, ptr.Elem())
+		}
+		v.Set(list)
+		return nil
+	}
+
+	ptr := reflect.New(v.Type())
+	if err := json.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+		return fmt.Errorf("invalid value for %s: %q", key, value)
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+// fileKeys reports which config keys a config file sets.
+func fileKeys(data []byte) map[string]bool {
+	var doc map[string]any
+	if json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+	keys := make(map[string]bool)
+	for _, f := range configFields() {
+		node := any(doc)
+		for _, part := range strings.Split(f.key, ".") {
+			m, ok := node.(map[string]any)
+			if !ok {
+				node = nil
+				break
+			}
+			if node, ok = m[part]; !ok {
+				node = nil
+				break
+			}
+		}
+		if node != nil {
+			keys[f.key] = true
+		}
+	}
+	return keys
+}
+
+// applyEnvOverrides sets every key that has a TENEX_RELAY_* variable.
+func applyEnvOverrides(config *Config, sources map[string]string) error {
+	known := make(map[string]bool)
+	for _, f := range configFields() {
+		name := envName(f.key)
+		known[name] = true
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setConfigValue(config, f.key, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		sources[f.key] = "env " + name
+	}
+
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(name, envPrefix) && !known[name] {
+			log.Printf("Ignoring unknown config variable %s", name)
+		}
+	}
+	return nil
+}
+
+// applySetOverrides applies key=value pairs from -set flags, in order.
+func applySetOverrides(config *Config, sets []string, sources map[string]string) error {
+	for _, set := range sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			return fmt.Errorf("-set %q: expected key=value", set)
+		}
+		key = strings.TrimSpace(key)
+		if err := setConfigValue(config, key, value); err != nil {
+			return fmt.Errorf("-set %s: %w", key, err)
+		}
+		sources[key] = "flag -set"
+	}
+	return nil
+}
+
+// printConfig writes every effective config value with its source.
+func printConfig(config *Config, sources map[string]string) {
+	root := reflect.ValueOf(config).Elem()
+	for _, f := range configFields() {
+		value, _ := json.Marshal(root.FieldByIndex(f.index).Interface())
+		source := sources[f.key]
+		if source == "" {
+			source = sourceDefault
+		}
+		fmt.Printf("%s = %s  (%s)\n", f.key, value, source)
+	}
+}
+
+// setFlags collects repeatable -set key=value flags.
+type setFlags []string
+
+func (s *setFlags) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *setFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
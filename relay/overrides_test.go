@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestConfigOverridesLayerFileEnvAndFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.json")
+	if err := os.WriteFile(path, []byte(`{"port": 7000, "limits": {"max_filters": 10, "max_subscriptions": 20}}`), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	t.Setenv("TENEX_RELAY_LIMITS_MAX_FILTERS", "30")
+	t.Setenv("TENEX_RELAY_SYNC_RELAYS", "wss://a.example, wss://b.example")
+	t.Setenv("TENEX_RELAY_LIMITS_EVENT_RATE_PER_SECOND", "2.5")
+
+	config, sources, err := loadConfigWithSources(path, []string{
+		"limits.max_subscriptions=40",
+		"nip11.name=Test Relay",
+		`quota.kinds={"4201":{"max_events":3}}`,
+	})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if config.Port != 7000 || sources["port"] != sourceFile {
+		t.Fatalf("expected port from the file, got %d (%s)", config.Port, sources["port"])
+	}
+	if config.Limits.MaxFilters != 30 || sources["limits.max_filters"] != "env TENEX_RELAY_LIMITS_MAX_FILTERS" {
+		t.Fatalf("expected the environment to override the file, got %d (%s)", config.Limits.MaxFilters, sources["limits.max_filters"])
+	}
+	if config.Limits.MaxSubscriptions != 40 || sources["limits.max_subscriptions"] != "flag -set" {
+		t.Fatalf("expected -set to override the file, got %d (%s)", config.Limits.MaxSubscriptions, sources["limits.max_subscriptions"])
+	}
+	if !slices.Equal(config.Sync.Relays, []string{"wss://a.example", "wss://b.example"}) {
+		t.Fatalf("unexpected sync relays %v", config.Sync.Relays)
+	}
+	if config.Limits.EventRate.PerSecond != 2.5 || config.NIP11.Name != "Test Relay" || config.Quota.Kinds[4201].MaxEvents != 3 {
+		t.Fatalf("unexpected overrides: %+v %q %+v", config.Limits.EventRate, config.NIP11.Name, config.Quota.Kinds)
+	}
+	if _, ok := sources["bind_address"]; ok {
+		t.Fatal("expected bind_address to be reported as a default")
+	}
+
+	if _, _, err := loadConfigWithSources(path, []string{"limits.nope=1"}); err == nil {
+		t.Fatal("expected an unknown key to fail")
+	}
+	if _, _, err := loadConfigWithSources(path, []string{"limits.max_filters=-1"}); err == nil {
+		t.Fatal("expected an override to be validated")
+	}
+}
+
+func TestConfigEnvNamesAreUnique(t *testing.T) {
+	seen := make(map[string]string)
+	for _, f := range configFields() {
+		name := envName(f.key)
+		if other, ok := seen[name]; ok {
+			t.Fatalf("%s and %s both map to %s", f.key, other, name)
+		}
+		seen[name] = f.key
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// quotaRecountInterval bounds drift in the incremental counters. Changes the
+// relay doesn't see, such as versions replaced inside the store by sync or
+// an offline import, are picked up when an author is next recounted.
+const quotaRecountInterval = 15 * time.Minute
+
+type quotaCounter struct {
+	events int
+	bytes  int64
+}
+
+func (c *quotaCounter) add(size int64, delta int) {
+	c.events += delta
+	c.bytes += size * int64(delta)
+	if c.events < 0 {
+		c.events = 0
+	}
+	if c.bytes < 0 {
+		c.bytes = 0
+	}
+}
+
+type quotaUsage struct {
+	quotaCounter
+	kinds     map[int]*quotaCounter
+	countedAt time.Time
+}
+
+// quotaTracker enforces per-author storage quotas. An author's usage is
+// counted from storage the first time they publish, then kept up to date as
+// the relay stores and deletes their events.
+type quotaTracker struct {
+	config   QuotaConfig
+	store    eventstore.Store
+	isExempt func(pubkey string) bool
+
+	mu    sync.Mutex
+	usage map[string]*quotaUsage
+}
+
+func newQuotaTracker(config QuotaConfig, store eventstore.Store, isExempt func(string) bool) *quotaTracker {
+	return &quotaTracker{
+		config:   config,
+		store:    store,
+		isExempt: isExempt,
+		usage:    make(map[string]*quotaUsage),
+	}
+}
+
+// RejectEvent turns away events that would take their author over quota.
+// A new version of a replaceable or addressable event the author already
+// has stored is let through, since it takes the old one's place; otherwise
+// an author at quota could never update their profile or edit an article.
+// A new address counts like any other event.
+func (q *quotaTracker) RejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	if nostr.IsEphemeralKind(event.Kind) || q.isExempt(event.PubKey) || q.replacesStored(ctx, event) {
+		return false, ""
+	}
+
+	usage, err := q.load(ctx, event.PubKey)
+	if err != nil {
+		logRelay.Warn("failed to count quota usage", "pubkey", event.PubKey, "err", err)
+		return false, ""
+	}
+
+	size := eventSize(event)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if reason := exceeds(usage.quotaCounter, size, q.config.MaxEvents, q.config.MaxBytes); reason != "" {
+		return true, "blocked: quota exceeded: " + reason
+	}
+	if limit, ok := q.config.Kinds[event.Kind]; ok {
+		var counter quotaCounter
+		if c := usage.kinds[event.Kind]; c != nil {
+			counter = *c
+		}
+		if reason := exceeds(counter, size, limit.MaxEvents, limit.MaxBytes); reason != "" {
+			return true, fmt.Sprintf("blocked: quota exceeded for kind %d: %s", event.Kind, reason)
+		}
+	}
+	return false, ""
+}
+
+// replacesStored reports whether event is a replaceable or addressable
+// event whose address already holds a stored event by the same author.
+func (q *quotaTracker) replacesStored(ctx context.Context, event *nostr.Event) bool {
+	filter := nostr.Filter{Authors: []string{event.PubKey}, Kinds: []int{event.Kind}, Limit: 1}
+	switch {
+	case nostr.IsReplaceableKind(event.Kind):
+	case nostr.IsAddressableKind(event.Kind):
+		filter.Tags = nostr.TagMap{"d": []string{event.Tags.GetD()}}
+	default:
+		return false
+	}
+
+	ch, err := q.store.QueryEvents(ctx, filter)
+	if err != nil {
+		logRelay.Warn("failed to look up replaced event", "pubkey", event.PubKey, "kind", event.Kind, "err", err)
+		return false
+	}
+	found := false
+	for range ch {
+		found = true
+	}
+	return found
+}
+
+func exceeds(c quotaCounter, size int64, maxEvents int, maxBytes int64) string {
+	if maxEvents > 0 && c.events+1 > maxEvents {
+		return fmt.Sprintf("%d events stored, limit is %d", c.events, maxEvents)
+	}
+	if maxBytes > 0 && c.bytes+size > maxBytes {
+		return fmt.Sprintf("%d bytes stored, limit is %d", c.bytes, maxBytes)
+	}
+	return ""
+}
+
+// load returns an author's usage, counting it from storage if it isn't
+// tracked yet or is due for a recount.
+func (q *quotaTracker) load(ctx context.Context, pubkey string) (*quotaUsage, error) {
+	q.mu.Lock()
+	usage := q.usage[pubkey]
+	q.mu.Unlock()
+	if usage != nil && time.Since(usage.countedAt) < quotaRecountInterval {
+		return usage, nil
+	}
+
+	counted := &quotaUsage{kinds: make(map[int]*quotaCounter), countedAt: time.Now()}
+	err := scanEvents(ctx, q.store, nostr.Filter{Authors: []string{pubkey}}, func(evt *nostr.Event) error {
+		counted.record(evt, 1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// A concurrent load may have stored a count while this one scanned, and
+	// track has been keeping that one current since; keep it.
+	if current := q.usage[pubkey]; current != usage {
+		return current, nil
+	}
+	q.usage[pubkey] = counted
+	return counted, nil
+}
+
+func (u *quotaUsage) record(evt *nostr.Event, delta int) {
+	size := eventSize(evt)
+	u.add(size, delta)
+	c := u.kinds[evt.Kind]
+	if c == nil {
+		c = &quotaCounter{}
+		u.kinds[evt.Kind] = c
+	}
+	c.add(size, delta)
+}
+
+// track applies a stored (+1) or deleted (-1) event to its author's usage.
+// Authors that aren't tracked yet are counted from storage when they next
+// publish, so there is nothing to update.
+func (q *quotaTracker) track(event *nostr.Event, delta int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if usage := q.usage[event.PubKey]; usage != nil {
+		usage.record(event, delta)
+	}
+}
+
+// StoreEvent runs after the primary store accepted an event.
+func (q *quotaTracker) StoreEvent(ctx context.Context, event *nostr.Event) error {
+	q.track(event, 1)
+	return nil
+}
+
+// DeleteEvent runs whenever the relay deletes an event.
+func (q *quotaTracker) DeleteEvent(ctx context.Context, event *nostr.Event) error {
+	q.track(event, -1)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestQuotaRejectsAuthorsOverLimit(t *testing.T) {
+	ctx := context.Background()
+	db, err := openStore(StorageConfig{Backend: storageMemory}, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer db.Close()
+
+	author := strings.Repeat("a", 64)
+	admin := strings.Repeat("b", 64)
+
+	seq := 0
+	newEvent := func(pubkey string, kind int) *nostr.Event {
+		seq++
+		return &nostr.Event{
+			ID:        strings.Repeat(fmt.Sprintf("%02d", seq), 32),
+			PubKey:    pubkey,
+			CreatedAt: nostr.Timestamp(1700000000 + seq),
+			Kind:      kind,
+			Tags:      nostr.Tags{},
+			Content:   "hello",
+			Sig:       strings.Repeat("c", 128),
+		}
+	}
+	article := func(d string) *nostr.Event {
+		evt := newEvent(author, 30023)
+		evt.Tags = nostr.Tags{{"d", d}}
+		return evt
+	}
+	// A note, a profile and an article are already stored before the
+	// tracker starts.
+	existing := newEvent(author, 1)
+	for _, evt := range []*nostr.Event{existing, newEvent(author, 0), article("post")} {
+		if err := db.SaveEvent(ctx, evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+	}
+
+	quota := newQuotaTracker(QuotaConfig{
+		Enabled:   true,
+		MaxEvents: 5,
+		Kinds:     map[int]KindQuota{4201: {MaxEvents: 1}},
+	}, db, func(pubkey string) bool { return pubkey == admin })
+
+	store := func(evt *nostr.Event) {
+		t.Helper()
+		if reject, msg := quota.RejectEvent(ctx, evt); reject {
+			t.Fatalf("unexpected rejection: %s", msg)
+		}
+		if err := db.SaveEvent(ctx, evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+		quota.StoreEvent(ctx, evt)
+	}
+
+	store(newEvent(author, 4201))
+
+	// The per-kind cap applies before the total.
+	reject, msg := quota.RejectEvent(ctx, newEvent(author, 4201))
+	if !reject || !strings.HasPrefix(msg, "blocked: quota exceeded for kind 4201") {
+		t.Fatalf("expected kind quota rejection, got %v %q", reject, msg)
+	}
+
+	store(newEvent(author, 1))
+	reject, msg = quota.RejectEvent(ctx, newEvent(author, 1))
+	if !reject || !strings.HasPrefix(msg, "blocked: quota exceeded") {
+		t.Fatalf("expected total quota rejection, got %v %q", reject, msg)
+	}
+
+	// A new profile replaces the stored one, so it isn't blocked.
+	if reject, msg := quota.RejectEvent(ctx, newEvent(author, 0)); reject {
+		t.Fatalf("expected replaceable event to be allowed, got %q", msg)
+	}
+	// Nor is an edit to a stored article, but a new address is.
+	if reject, msg := quota.RejectEvent(ctx, article("post")); reject {
+		t.Fatalf("expected addressable event to be allowed, got %q", msg)
+	}
+	reject, msg = quota.RejectEvent(ctx, article("another post"))
+	if !reject || !strings.HasPrefix(msg, "blocked: quota exceeded") {
+		t.Fatalf("expected a new address to count against the quota, got %v %q", reject, msg)
+	}
+	// Someone else's profile isn't a replacement.
+	other := strings.Repeat("d", 64)
+	for i := 0; i < 5; i++ {
+		store(newEvent(other, 1))
+	}
+	if reject, _ := quota.RejectEvent(ctx, newEvent(other, 0)); !reject {
+		t.Fatal("expected a first profile at quota to be rejected")
+	}
+
+	// Deleting frees the quota again.
+	if err := db.DeleteEvent(ctx, existing); err != nil {
+		t.Fatalf("failed to delete event: %v", err)
+	}
+	quota.DeleteEvent(ctx, existing)
+	if reject, msg := quota.RejectEvent(ctx, newEvent(author, 1)); reject {
+		t.Fatalf("expected event to be allowed after a deletion, got %q", msg)
+	}
+
+	for i := 0; i < 5; i++ {
+		store(newEvent(admin, 4201))
+	}
+}
+
+func TestQuotaConcurrentLoadsKeepTrackedUsage(t *testing.T) {
+	ctx := context.Background()
+	db, err := openStore(StorageConfig{Backend: storageMemory}, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer db.Close()
+
+	store := &blockingStore{Store: db, entered: make(chan struct{}), release: make(chan struct{})}
+	quota := newQuotaTracker(QuotaConfig{Enabled: true, MaxEvents: 10}, store, func(string) bool { return false })
+	author := strings.Repeat("a", 64)
+
+	// The first scan stalls while a second load counts the author and an
+	// event is tracked against that count.
+	slow := make(chan *quotaUsage)
+	go func() {
+		usage, _ := quota.load(ctx, author)
+		slow <- usage
+	}()
+	<-store.entered
+	if _, err := quota.load(ctx, author); err != nil {
+		t.Fatalf("failed to load usage: %v", err)
+	}
+	quota.StoreEvent(ctx, &nostr.Event{PubKey: author, Kind: 1, Content: "hello"})
+
+	close(store.release)
+	if usage := <-slow; usage.events != 1 {
+		t.Fatalf("expected the stalled load to return the tracked count, got %d events", usage.events)
+	}
+	if usage, _ := quota.load(ctx, author); usage.events != 1 {
+		t.Fatalf("expected the tracked event to be kept, got %d events", usage.events)
+	}
+}
+
+// blockingStore holds its first query until release is closed.
+type blockingStore struct {
+	eventstore.Store
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+	first := false
+	s.once.Do(func() { first = true })
+	if first {
+		close(s.entered)
+		<-s.release
+	}
+	return s.Store.QueryEvents(ctx, filter)
+}
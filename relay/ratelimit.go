@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// rateLimitSweepInterval is how often idle buckets are dropped. A bucket
+// that has refilled completely is indistinguishable from a new one.
+const rateLimitSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// tokenBuckets is a set of token buckets keyed by caller. The limit is
+// passed on each call so a config reload applies to existing buckets.
+type tokenBuckets struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newTokenBuckets() *tokenBuckets {
+	return &tokenBuckets{buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from key's bucket, reporting false if it is empty.
+func (b *tokenBuckets) allow(key string, limit RateLimit, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Sub(b.lastSweep) >= rateLimitSweepInterval {
+		for k, bucket := range b.buckets {
+			if refill(bucket, limit, now) >= float64(limit.Burst) {
+				delete(b.buckets, k)
+			}
+		}
+		b.lastSweep = now
+	}
+
+	bucket := b.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		b.buckets[key] = bucket
+	}
+	bucket.tokens = refill(bucket, limit, now)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func refill(bucket *tokenBucket, limit RateLimit, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.last).Seconds()*limit.PerSecond
+	return min(tokens, float64(limit.Burst))
+}
+
+// rateLimitExemptions lists the clients no rate limit applies to, such as
+// the TENEX daemon, whose agents all connect from one loopback address.
+type rateLimitExemptions struct {
+	prefixes []netip.Prefix
+	pubkeys  map[string]bool
+}
+
+func newRateLimitExemptions(limits LimitsConfig) *rateLimitExemptions {
+	e := &rateLimitExemptions{pubkeys: make(map[string]bool, len(limits.RateLimitExemptPubkeys))}
+	for _, entry := range limits.RateLimitExemptIPs {
+		// Entries were checked by Config.Validate.
+		if prefix, err := parseIPPrefix(entry); err == nil {
+			e.prefixes = append(e.prefixes, prefix)
+		}
+	}
+	for _, pubkey := range limits.RateLimitExemptPubkeys {
+		e.pubkeys[pubkey] = true
+	}
+	return e
+}
+
+// parseIPPrefix accepts a single address or a CIDR range.
+func parseIPPrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", entry)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (e *rateLimitExemptions) IP(ip string) bool {
+	if len(e.prefixes) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range e.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *rateLimitExemptions) Pubkey(pubkey string) bool {
+	return pubkey != "" && e.pubkeys[pubkey]
+}
+
+// filterRateLimiter throttles REQ and COUNT filters per IP.
+func filterRateLimiter(live *liveConfig) func(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	buckets := newTokenBuckets()
+	return func(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+		listener := connListener(ctx)
+		limits, policy := live.LimitsFor(listener)
+		limit := limits.FilterRate
+		if limit.PerSecond <= 0 || policy.Trusted {
+			return false, ""
+		}
+		ip := khatru.GetIP(ctx)
+		if exempt := live.Exempt(); exempt.IP(ip) || exempt.Pubkey(khatru.GetAuthed(ctx)) {
+			return false, ""
+		}
+		if !buckets.allow(listener+"/"+ip, limit, time.Now()) {
+			return true, "rate-limited: too many requests, slow down"
+		}
+		return false, ""
+	}
+}
+
+// connectionRateLimiter throttles new websocket connections per IP.
+func connectionRateLimiter(live *liveConfig) func(r *http.Request) bool {
+	buckets := newTokenBuckets()
+	return func(r *http.Request) bool {
+		listener := requestListener(r)
+		limits, policy := live.LimitsFor(listener)
+		limit := limits.ConnectionRate
+		if limit.PerSecond <= 0 || policy.Trusted {
+			return false
+		}
+		ip := khatru.GetIPFromRequest(r)
+		if live.Exempt().IP(ip) {
+			return false
+		}
+		return !buckets.allow(listener+"/"+ip, limit, time.Now())
+	}
+}
+
+// eventRateLimiter throttles EVENT writes per publisher. Publishers are
+// keyed by authenticated pubkey, else by the event's author on the IP it
+// came from: scoping the author to the IP keeps clients sharing localhost
+// apart, while anyone rebroadcasting an author's events spends their own
+// bucket rather than the author's.
+type eventRateLimiter struct {
+	live      *liveConfig
+	regular   *tokenBuckets
+	ephemeral *tokenBuckets
+}
+
+func newEventRateLimiter(live *liveConfig) *eventRateLimiter {
+	return &eventRateLimiter{
+		live:      live,
+		regular:   newTokenBuckets(),
+		ephemeral: newTokenBuckets(),
+	}
+}
+
+func (l *eventRateLimiter) RejectEvent(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+	listener := connListener(ctx)
+	limits, policy := l.live.LimitsFor(listener)
+	buckets, limit, class := l.regular, limits.EventRate, "events"
+	if nostr.IsEphemeralKind(event.Kind) {
+		buckets, limit, class = l.ephemeral, limits.EphemeralEventRate, "ephemeral events"
+	}
+	if limit.PerSecond <= 0 || policy.Trusted {
+		return false, ""
+	}
+	exempt := l.live.Exempt()
+	if exempt.Pubkey(khatru.GetAuthed(ctx)) || exempt.IP(khatru.GetIP(ctx)) {
+		return false, ""
+	}
+	if !buckets.allow(listener+"/"+rateLimitKey(ctx, event), limit, time.Now()) {
+		return true, "rate-limited: too many " + class + ", slow down"
+	}
+	return false, ""
+}
+
+func rateLimitKey(ctx context.Context, event *nostr.Event) string {
+	if pubkey := khatru.GetAuthed(ctx); pubkey != "" {
+		return "pubkey:" + pubkey
+	}
+	return "ip:" + khatru.GetIP(ctx) + "/author:" + event.PubKey
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestTokenBucketsRefillOverTime(t *testing.T) {
+	buckets := newTokenBuckets()
+	limit := RateLimit{PerSecond: 2, Burst: 3}
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if !buckets.allow("a", limit, now) {
+			t.Fatalf("expected burst event %d to be allowed", i)
+		}
+	}
+	if buckets.allow("a", limit, now) {
+		t.Fatal("expected the empty bucket to reject")
+	}
+	if !buckets.allow("b", limit, now) {
+		t.Fatal("expected another key to have its own bucket")
+	}
+
+	// Half a second refills one token at 2/s.
+	now = now.Add(500 * time.Millisecond)
+	if !buckets.allow("a", limit, now) {
+		t.Fatal("expected a refilled token to be allowed")
+	}
+	if buckets.allow("a", limit, now) {
+		t.Fatal("expected only one token to have refilled")
+	}
+
+	// Idle buckets are dropped once they have refilled.
+	now = now.Add(rateLimitSweepInterval)
+	buckets.allow("c", limit, now)
+	if len(buckets.buckets) != 1 {
+		t.Fatalf("expected idle buckets to be swept, have %d", len(buckets.buckets))
+	}
+}
+
+func TestEventRateLimiterSeparatesKindClasses(t *testing.T) {
+	ctx := context.Background()
+	limits := LimitsConfig{
+		EventRate:          RateLimit{PerSecond: 0.001, Burst: 1},
+		EphemeralEventRate: RateLimit{PerSecond: 0.001, Burst: 2},
+	}
+	limiter := newEventRateLimiter(newLiveConfig(&Config{Limits: limits}))
+	author := strings.Repeat("a", 64)
+	event := func(kind int) *nostr.Event {
+		return &nostr.Event{PubKey: author, Kind: kind}
+	}
+
+	if reject, msg := limiter.RejectEvent(ctx, event(1)); reject {
+		t.Fatalf("unexpected rejection: %s", msg)
+	}
+	reject, msg := limiter.RejectEvent(ctx, event(1))
+	if !reject || !strings.HasPrefix(msg, "rate-limited:") {
+		t.Fatalf("expected regular event to be rate limited, got %v %q", reject, msg)
+	}
+
+	// Ephemeral events draw from their own budget.
+	for i := 0; i < 2; i++ {
+		if reject, msg := limiter.RejectEvent(ctx, event(24133)); reject {
+			t.Fatalf("unexpected ephemeral rejection: %s", msg)
+		}
+	}
+	if reject, _ := limiter.RejectEvent(ctx, event(24133)); !reject {
+		t.Fatal("expected ephemeral event to be rate limited")
+	}
+
+	// Unauthenticated publishers are keyed by author on their IP, so other
+	// authors keep their own budget.
+	other := &nostr.Event{PubKey: strings.Repeat("b", 64), Kind: 1}
+	if reject, msg := limiter.RejectEvent(ctx, other); reject {
+		t.Fatalf("expected another author to be unaffected, got %q", msg)
+	}
+}
+
+func TestRateLimitExemptions(t *testing.T) {
+	daemon := strings.Repeat("d", 64)
+	limits := LimitsConfig{
+		EventRate:              RateLimit{PerSecond: 0.001, Burst: 1},
+		RateLimitExemptIPs:     []string{"127.0.0.1", "10.0.0.0/8"},
+		RateLimitExemptPubkeys: []string{daemon},
+	}
+	exempt := newRateLimitExemptions(limits)
+
+	for ip, want := range map[string]bool{
+		"127.0.0.1":        true,
+		"::ffff:127.0.0.1": true,
+		"10.20.30.40":      true,
+		"192.168.1.1":      false,
+		"":                 false,
+	} {
+		if got := exempt.IP(ip); got != want {
+			t.Errorf("IP(%q) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if !exempt.Pubkey(daemon) || exempt.Pubkey(strings.Repeat("e", 64)) {
+		t.Error("expected only the listed pubkey to be exempt")
+	}
+
+	// The exempt pubkey has to be authenticated: anyone can rebroadcast
+	// events it authored.
+	limiter := newEventRateLimiter(newLiveConfig(&Config{Limits: limits}))
+	if reject, msg := limiter.RejectEvent(context.Background(), &nostr.Event{PubKey: daemon, Kind: 1}); reject {
+		t.Fatalf("unexpected rejection: %s", msg)
+	}
+	if reject, _ := limiter.RejectEvent(context.Background(), &nostr.Event{PubKey: daemon, Kind: 1}); !reject {
+		t.Fatal("expected an unauthenticated event by the exempt pubkey to be rate limited")
+	}
+
+	config := DefaultConfig()
+	config.Limits.RateLimitExemptIPs = []string{"localhost"}
+	if err := config.Validate(); err == nil {
+		t.Fatal("expected an invalid exempt IP to fail validation")
+	}
+}
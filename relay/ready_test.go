@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyReportsEachCheck(t *testing.T) {
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Search.Enabled = false
+
+	relay, err := NewRelay(config)
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	defer relay.db.Close()
+
+	ready := func() (int, map[string]readyCheck) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		relay.handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var body struct {
+			Ready  bool                  `json:"ready"`
+			Checks map[string]readyCheck `json:"checks"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid /ready response %q: %v", rec.Body, err)
+		}
+		if body.Ready != (rec.Code == http.StatusOK) {
+			t.Fatalf("ready=%v does not match status %d", body.Ready, rec.Code)
+		}
+		return rec.Code, body.Checks
+	}
+
+	code, checks := ready()
+	if code != http.StatusOK {
+		t.Fatalf("expected a fresh relay to be ready, got %d %+v", code, checks)
+	}
+	if c := checks["storage"]; !c.OK || c.Detail != "badger read/write" {
+		t.Fatalf("expected a badger round trip, got %+v", c)
+	}
+
+	next := *config
+	next.Sync.MinConnected = 1
+	relay.live.store(&next)
+	if code, checks := ready(); code != http.StatusServiceUnavailable || checks["sync"].OK || !checks["storage"].OK {
+		t.Fatalf("expected only the sync check to fail, got %d %+v", code, checks)
+	}
+	relay.live.store(config)
+
+	relay.SetMode(modeMaintenance, "test")
+	if code, checks := ready(); code != http.StatusServiceUnavailable || checks["mode"].OK {
+		t.Fatalf("expected maintenance mode to fail readiness, got %d %+v", code, checks)
+	}
+}
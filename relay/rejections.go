@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxRecentRejections is how many rejected EVENTs the admin API remembers.
+const maxRecentRejections = 100
+
+// rejectionLog logs rejected EVENTs, keeps the most recent ones for the
+// admin dashboard and publishes them to the firehose.
+type rejectionLog struct {
+	firehose *firehose
+
+	mu     sync.Mutex
+	recent []rejection // ring buffer, next is the oldest once full
+	next   int
+}
+
+type rejection struct {
+	Time    time.Time `json:"time"`
+	EventID string    `json:"event_id"`
+	Kind    int       `json:"kind"`
+	Pubkey  string    `json:"pubkey"`
+	IP      string    `json:"ip,omitempty"`
+	Reason  string    `json:"reason"`
+}
+
+func (l *rejectionLog) record(ctx context.Context, event *nostr.Event, reason string) {
+	if reason == "" {
+		reason = "blocked: no reason provided"
+	}
+	ip := khatru.GetIP(ctx)
+	logRelay.Info("rejected EVENT", "event_id", event.ID, "kind", event.Kind, "pubkey", event.PubKey, "ip", ip, "reason", reason)
+	if l.firehose != nil {
+		l.firehose.rejected(ctx, event, reason)
+	}
+
+	r := rejection{Time: time.Now().UTC(), EventID: event.ID, Kind: event.Kind, Pubkey: event.PubKey, IP: ip, Reason: reason}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.recent) < maxRecentRejections {
+		l.recent = append(l.recent, r)
+		return
+	}
+	l.recent[l.next] = r
+	l.next = (l.next + 1) % maxRecentRejections
+}
+
+// wrap logs and records what reject rejects.
+func (l *rejectionLog) wrap(reject func(context.Context, *nostr.Event) (bool, string)) func(context.Context, *nostr.Event) (bool, string) {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		rejected, msg := reject(ctx, event)
+		if rejected {
+			l.record(ctx, event, msg)
+		}
+		return rejected, msg
+	}
+}
+
+// Recent returns the remembered rejections, newest first.
+func (l *rejectionLog) Recent() []rejection {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := make([]rejection, 0, len(l.recent))
+	for i := len(l.recent) - 1; i >= 0; i-- {
+		list = append(list, l.recent[(l.next+i)%len(l.recent)])
+	}
+	return list
+}
+
+func (r *Relay) handleRejections(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"rejections": r.rejections.Recent()})
+}
@@ -4,18 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
 	"github.com/fiatjaf/eventstore"
 	evbadger "github.com/fiatjaf/eventstore/badger"
+	"github.com/fiatjaf/eventstore/wrappers/count"
+	"github.com/fiatjaf/eventstore/wrappers/disablesearch"
 	"github.com/fiatjaf/khatru"
 	"github.com/fiatjaf/khatru/policies"
 	"github.com/nbd-wtf/go-nostr"
@@ -28,15 +31,43 @@ func silentBadger(opts badger.Options) badger.Options {
 
 // Relay wraps a Khatru relay with TENEX-specific configuration
 type Relay struct {
+	// config is the configuration the relay started with; live holds the
+	// one currently in force after any reloads.
 	config *Config
+	live   *liveConfig
 	khatru *khatru.Relay
-	server *http.Server
-	db     eventstore.Store
-	syncer *Syncer
-	acl    *ACL
-
-	mu        sync.RWMutex
-	startTime time.Time
+	// servers has one entry per listener
+	servers []*http.Server
+	db      eventstore.Store
+	syncer  *Syncer
+	acl     *ACL
+	search  *searchIndex
+
+	// backups is nil unless the store is Badger
+	backups *backupManager
+	disk    *diskManager
+	quota   *quotaTracker // nil unless quotas are enabled
+
+	bans          *banList
+	rejections    *rejectionLog
+	subscriptions *subscriptionLimiter
+	firehose      *firehose
+
+	ephemeral   *ephemeralEventCache
+	replayGuard *historicalQueryReplayGuard
+	mode        *relayMode
+
+	// Shutdown state: connections to say goodbye to, store writes to wait
+	// for, and background work started by Start and WatchConfig.
+	shuttingDown *atomic.Bool
+	conns        *connTracker
+	writes       *writeTracker
+	background   sync.WaitGroup
+	cancel       context.CancelFunc
+
+	mu         sync.RWMutex
+	startTime  time.Time
+	adminToken string
 }
 
 // NewRelay creates a new relay with the given configuration
@@ -45,37 +76,90 @@ func NewRelay(config *Config) (*Relay, error) {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	dbImpl := &evbadger.BadgerBackend{
-		Path:                  filepath.Join(config.DataDir, "badger"),
-		BadgerOptionsModifier: silentBadger,
+	bans, err := loadBanList(config.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load banned pubkeys: %w", err)
+	}
+
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s storage: %w", config.Storage.Backend, err)
+	}
+
+	var search *searchIndex
+	if config.Search.Enabled {
+		// An in-memory store starts empty, so an index left on disk is stale.
+		if config.Storage.Backend == storageMemory {
+			if err := resetSearchIndex(config.DataDir); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("failed to reset search index: %w", err)
+			}
+		}
+		search, err = newSearchIndex(config.Search, config.DataDir, db)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize search index: %w", err)
+		}
 	}
-	if err := dbImpl.Init(); err != nil {
-		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+
+	acl := NewACL(config.AdminPubkeys, db)
+
+	var quota *quotaTracker
+	if config.Quota.Enabled {
+		quota = newQuotaTracker(config.Quota, db, acl.IsAdmin)
 	}
-	var db eventstore.Store = dbImpl
 
+	live := newLiveConfig(config)
+	mode := newRelayMode(config.Mode)
+	conns := newConnTracker()
+	firehose := newFirehose()
+	rejections := &rejectionLog{firehose: firehose}
+	writes := &writeTracker{}
+	shuttingDown := &atomic.Bool{}
 	relay := khatru.NewRelay()
-	ephemeralCache := newEphemeralEventCache(ephemeralEventRetention)
+	ephemeralCache := newEphemeralEventCache(time.Duration(config.Limits.EphemeralRetentionSeconds) * time.Second)
 	relay.MaxMessageSize = int64(config.Limits.MaxMessageLength)
-	recentHistoricalQueries := newHistoricalQueryReplayGuard(5 * time.Second)
+	recentHistoricalQueries := newHistoricalQueryReplayGuard(time.Duration(config.Limits.HistoricalReplayWindowSeconds) * time.Second)
 
 	relay.Info.Name = config.NIP11.Name
 	relay.Info.Description = config.NIP11.Description
 	relay.Info.PubKey = config.NIP11.Pubkey
 	relay.Info.Contact = config.NIP11.Contact
-	supportedNIPs := make([]any, len(config.NIP11.SupportedNIPs))
-	for i, nip := range config.NIP11.SupportedNIPs {
-		supportedNIPs[i] = nip
+	supportedNIPs := make([]any, 0, len(config.NIP11.SupportedNIPs)+1)
+	for _, nip := range config.NIP11.SupportedNIPs {
+		supportedNIPs = append(supportedNIPs, nip)
+	}
+	if search != nil && !slices.Contains(config.NIP11.SupportedNIPs, 50) {
+		supportedNIPs = append(supportedNIPs, 50)
 	}
 	relay.Info.SupportedNIPs = supportedNIPs
 	relay.Info.Software = config.NIP11.Software
 	relay.Info.Version = config.NIP11.Version
+	relay.Info.Icon = config.NIP11.Icon
+	relay.Info.Banner = config.NIP11.Banner
+	relay.Info.Limitation = relayLimitation(config)
 
 	relay.StoreEvent = append(relay.StoreEvent, db.SaveEvent)
 	relay.OnEphemeralEvent = append(relay.OnEphemeralEvent, ephemeralCache.Store)
-	relay.QueryEvents = append(relay.QueryEvents, ephemeralCache.QueryEvents, instrumentQueryEvents(db.QueryEvents))
+	// Search filters are answered by the index only; some backends would
+	// otherwise ignore the search term and return everything else matching.
+	relay.QueryEvents = append(relay.QueryEvents, ephemeralCache.QueryEvents, instrumentQueryEvents(disablesearch.Wrapper{Store: db}.QueryEvents))
 	relay.DeleteEvent = append(relay.DeleteEvent, db.DeleteEvent)
-	relay.CountEvents = append(relay.CountEvents, dbImpl.CountEvents)
+	relay.CountEvents = append(relay.CountEvents, count.Wrapper{Store: db}.CountEvents)
+	if quota != nil {
+		relay.StoreEvent = append(relay.StoreEvent, quota.StoreEvent)
+		relay.DeleteEvent = append(relay.DeleteEvent, quota.DeleteEvent)
+	}
+
+	// NIP-50: serve search filters from the full-text index
+	searchPolicy := policies.NoSearchQueries
+	if search != nil {
+		relay.QueryEvents = append(relay.QueryEvents, search.QueryEvents)
+		acl.searchEvents = search.QueryEvents
+		relay.DeleteEvent = append(relay.DeleteEvent, search.DeleteEvent)
+		relay.OnEventSaved = append(relay.OnEventSaved, search.Index)
+		searchPolicy = search.RejectFilter
+	}
 
 	// NIP-9: handle deletion events (kind 5)
 	relay.OnEventSaved = append(relay.OnEventSaved, func(ctx context.Context, event *nostr.Event) {
@@ -87,57 +171,76 @@ func NewRelay(config *Config) (*Relay, error) {
 				targetID := tag[1]
 				ch, err := db.QueryEvents(ctx, nostr.Filter{IDs: []string{targetID}, Limit: 1})
 				if err != nil {
-					log.Printf("NIP-9: failed to query event %s: %v", targetID, err)
+					logRelay.Warn("NIP-9: failed to query deletion target", "event_id", targetID, "err", err)
 					continue
 				}
 				for targetEvent := range ch {
 					if targetEvent.PubKey == event.PubKey {
 						if err := db.DeleteEvent(ctx, targetEvent); err != nil {
-							log.Printf("NIP-9: failed to delete event %s: %v", targetID, err)
+							logRelay.Warn("NIP-9: failed to delete event", "event_id", targetID, "err", err)
 						} else {
-							log.Printf("NIP-9: deleted event %s (requested by %s...)", truncateForLog(targetID, 12), truncateForLog(event.PubKey, 12))
+							if search != nil {
+								search.DeleteEvent(ctx, targetEvent)
+							}
+							if quota != nil {
+								quota.DeleteEvent(ctx, targetEvent)
+							}
+							logRelay.Info("NIP-9: deleted event", "event_id", targetID, "kind", targetEvent.Kind, "pubkey", event.PubKey)
 						}
 					} else {
-						log.Printf("NIP-9: ignoring deletion request for %s (pubkey mismatch)", truncateForLog(targetID, 12))
+						logRelay.Info("NIP-9: ignoring deletion request from another pubkey", "event_id", targetID, "pubkey", event.PubKey)
 					}
 				}
 			}
 		}
 	})
 
-	preventLargeTags := policies.PreventLargeTags(config.Limits.MaxEventTags)
-	queryRateLimiter := policies.FilterIPRateLimiter(20, time.Second, 40)
+	queryRateLimiter := filterRateLimiter(live)
+	eventRateLimiter := newEventRateLimiter(live)
+	listenerEventPolicy := rejectEventByListener(live)
 	relay.RejectEvent = append(relay.RejectEvent,
-		func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
-			reject, msg = preventLargeTags(ctx, event)
-			if reject {
-				logRejectedEventWrite(ctx, event, msg)
+		firehose.wrapReject(func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+			if shuttingDown.Load() {
+				return true, errShuttingDown.Error()
 			}
-			return reject, msg
-		},
-		func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
-			if len(event.Content) > config.Limits.MaxContentLength {
-				msg := fmt.Sprintf("content too large: %d > %d bytes", len(event.Content), config.Limits.MaxContentLength)
-				logRejectedEventWrite(ctx, event, msg)
-				return true, msg
+			return false, ""
+		}),
+		// Not logged per event: the mode change itself is.
+		firehose.wrapReject(mode.RejectEvent),
+		rejections.wrap(bans.RejectEvent),
+		rejections.wrap(listenerEventPolicy),
+		rejections.wrap(eventRateLimiter.RejectEvent),
+		rejections.wrap(func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+			return policies.PreventLargeTags(live.Limits().MaxEventTags)(ctx, event)
+		}),
+		rejections.wrap(func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
+			if max := live.Limits().MaxContentLength; len(event.Content) > max {
+				return true, fmt.Sprintf("content too large: %d > %d bytes", len(event.Content), max)
 			}
 			return false, ""
-		},
+		}),
 	)
+	if quota != nil {
+		relay.RejectEvent = append(relay.RejectEvent, rejections.wrap(quota.RejectEvent))
+	}
 
 	relay.RejectConnection = append(relay.RejectConnection,
-		policies.ConnectionRateLimiter(10, time.Second, 20),
+		connectionRateLimiter(live),
 		func(r *http.Request) bool { return false },
 	)
 
+	subscriptions := newSubscriptionLimiter(live)
 	relay.RejectFilter = append(relay.RejectFilter,
+		bans.RejectFilter,
+		subscriptions.RejectFilter,
 		queryRateLimiter,
 		func(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
-			if khatru.GetAuthed(ctx) != "" {
+			policy := live.Policy(connListener(ctx))
+			if policy.Trusted || khatru.GetAuthed(ctx) != "" {
 				return false, ""
 			}
 			// Allow unauthenticated subscriptions for ephemeral-only filters
-			if len(filter.Kinds) > 0 {
+			if len(filter.Kinds) > 0 && !policy.RequireAuth {
 				allEphemeral := true
 				for _, k := range filter.Kinds {
 					if !isEphemeral(k) {
@@ -152,7 +255,7 @@ func NewRelay(config *Config) (*Relay, error) {
 			khatru.RequestAuth(ctx)
 			return true, "auth-required: authenticate to subscribe"
 		},
-		policies.NoSearchQueries,
+		searchPolicy,
 		policies.NoEmptyFilters,
 		func(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
 			return rejectBroadHistoricalCountFilter(filter)
@@ -160,6 +263,7 @@ func NewRelay(config *Config) (*Relay, error) {
 	)
 
 	relay.RejectCountFilter = append(relay.RejectCountFilter,
+		rejectCountByListener(live),
 		queryRateLimiter,
 		policies.NoSearchQueries,
 		policies.NoEmptyFilters,
@@ -168,63 +272,178 @@ func NewRelay(config *Config) (*Relay, error) {
 		},
 	)
 
+	relay.OnConnect = append(relay.OnConnect, conns.OnConnect)
+	relay.OnDisconnect = append(relay.OnDisconnect, conns.OnDisconnect)
+	relay.OverwriteFilter = append(relay.OverwriteFilter, conns.OverwriteFilter)
+
 	relay.OverwriteFilter = append(relay.OverwriteFilter, func(ctx context.Context, filter *nostr.Filter) {
-		normalizeQueryFilter(filter, config.Limits)
+		normalizeQueryFilter(filter, live.Limits())
 		recentHistoricalQueries.Apply(ctx, filter)
 	})
 
-	acl := NewACL(config.AdminPubkeys, db)
-	relay.OverwriteFilter = append(relay.OverwriteFilter, acl.OverwriteFilterHook)
-	relay.PreventBroadcast = append(relay.PreventBroadcast, acl.PreventBroadcastHook)
-	relay.OnEventSaved = append(relay.OnEventSaved, acl.OnEventSavedHook)
+	relay.OverwriteFilter = append(relay.OverwriteFilter, func(ctx context.Context, filter *nostr.Filter) {
+		if live.Policy(connListener(ctx)).Trusted {
+			return
+		}
+		acl.OverwriteFilterHook(ctx, filter)
+	})
+	// Must stay last: it may undo LimitZero set by the hooks above.
+	relay.OverwriteFilter = append(relay.OverwriteFilter, subscriptions.OverwriteFilter)
+	relay.PreventBroadcast = append(relay.PreventBroadcast, func(ws *khatru.WebSocket, event *nostr.Event) bool {
+		if live.Policy(requestListener(ws.Request)).Trusted {
+			return false
+		}
+		return acl.PreventBroadcastHook(ws, event)
+	})
+	// Must stay last: it counts the events that are sent.
+	relay.PreventBroadcast = append(relay.PreventBroadcast, conns.PreventBroadcast)
+	relay.OverwriteResponseEvent = append(relay.OverwriteResponseEvent, conns.OverwriteResponseEvent)
+	relay.OnEventSaved = append(relay.OnEventSaved, acl.OnEventSavedHook, firehose.OnEventSaved)
+	relay.OnEphemeralEvent = append(relay.OnEphemeralEvent, firehose.OnEphemeralEvent)
+	// After every write hook is registered.
+	writes.track(relay)
+
+	var backups *backupManager
+	if b, ok := db.(*evbadger.BadgerBackend); ok {
+		backups = newBackupManager(config.Backup, config.DataDir, b)
+	}
+
+	disk := newDiskManager(config, db)
+	disk.OnPrune = func(ctx context.Context, event *nostr.Event) {
+		if search != nil {
+			search.DeleteEvent(ctx, event)
+		}
+		if quota != nil {
+			quota.DeleteEvent(ctx, event)
+		}
+	}
 
 	return &Relay{
-		config: config,
-		khatru: relay,
-		db:     db,
-		acl:    acl,
+		config:      config,
+		live:        live,
+		khatru:      relay,
+		db:          db,
+		acl:         acl,
+		search:      search,
+		backups:     backups,
+		disk:        disk,
+		quota:       quota,
+		ephemeral:   ephemeralCache,
+		replayGuard: recentHistoricalQueries,
+		mode:        mode,
+
+		bans:          bans,
+		rejections:    rejections,
+		subscriptions: subscriptions,
+		firehose:      firehose,
+
+		shuttingDown: shuttingDown,
+		conns:        conns,
+		writes:       writes,
 	}, nil
 }
 
 // Start starts the relay server
 func (r *Relay) Start(ctx context.Context) error {
+	// Shutdown cancels the background work started below.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.mu.Lock()
+	r.cancel = cancel
+	r.mu.Unlock()
+
+	adminToken, err := writeAdminToken(r.config.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to write admin token: %w", err)
+	}
+
 	r.mu.Lock()
 	r.startTime = time.Now()
+	r.adminToken = adminToken
 	r.mu.Unlock()
 	r.acl.StartWhitelistFileSync(ctx)
 
+	if r.search != nil && r.search.needsRebuild {
+		r.background.Add(1)
+		go func() {
+			defer r.background.Done()
+			logSearch.Info("building index from storage")
+			if err := r.search.Rebuild(ctx); err != nil && ctx.Err() == nil {
+				logSearch.Error("index rebuild failed", "err", err)
+			}
+		}()
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", r.handleHealth)
-	mux.Handle("/", r.khatru)
-
-	addr := fmt.Sprintf("%s:%d", r.config.BindAddress, r.config.Port)
-	r.server = &http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	mux.HandleFunc("/ready", r.handleReady)
+	// Stats name sync upstreams and their errors, so they are admin-only.
+	mux.HandleFunc("/stats", r.requireAdmin(r.handleStats))
+	mux.HandleFunc("GET /admin/export", r.requireAdmin(r.handleExport))
+	mux.HandleFunc("POST /admin/snapshot", r.requireAdmin(r.handleSnapshot))
+	mux.HandleFunc("POST /admin/compact", r.requireAdmin(r.handleCompact))
+	mux.HandleFunc("GET /admin/mode", r.requireAdmin(r.handleMode))
+	mux.HandleFunc("POST /admin/mode", r.requireAdmin(r.handleMode))
+	// The dashboard page is public; everything it shows needs admin auth.
+	mux.HandleFunc("GET /admin/{$}", r.handleDashboard)
+	mux.HandleFunc("GET /admin/connections", r.requireAdmin(r.handleConnections))
+	mux.HandleFunc("DELETE /admin/connections/{id}", r.requireAdmin(r.handleKick))
+	mux.HandleFunc("DELETE /admin/connections/{id}/subscriptions/{sub}", r.requireAdmin(r.handleCloseSubscription))
+	mux.HandleFunc("GET /admin/acl", r.requireAdmin(r.handleACL))
+	mux.HandleFunc("GET /admin/rejections", r.requireAdmin(r.handleRejections))
+	mux.HandleFunc("GET /admin/kinds", r.requireAdmin(r.handleKinds))
+	mux.HandleFunc("GET /admin/sync", r.requireAdmin(r.handleSyncStatus))
+	mux.HandleFunc("POST /admin/sync/resync", r.requireAdmin(r.handleResync))
+	mux.HandleFunc("GET /admin/firehose", r.requireAdmin(r.handleFirehose))
+	mux.HandleFunc("POST /admin/import", r.requireAdmin(r.handleImport))
+	mux.HandleFunc("GET /admin/bans", r.requireAdmin(r.handleBans))
+	mux.HandleFunc("POST /admin/bans", r.requireAdmin(r.handleBans))
+	mux.HandleFunc("DELETE /admin/bans/{pubkey}", r.requireAdmin(r.handleUnban))
+	mux.Handle("/", r.withNIP11Extras(r.khatru))
+
+	listeners, err := r.listen(withUnixPeerAddr(r.withMaintenance(mux)))
+	if err != nil {
+		return err
 	}
+	r.mu.Lock()
+	for _, l := range listeners {
+		r.servers = append(r.servers, l.server)
+	}
+	r.mu.Unlock()
 
-	log.Printf("Starting TENEX relay on %s", addr)
-	log.Printf("NIP-11 Info: %s - %s", r.config.NIP11.Name, r.config.NIP11.Description)
-
-	errCh := make(chan error, 1)
-	go func() {
-		if err := r.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			errCh <- err
-		}
-	}()
+	logRelay.Info("NIP-11 info", "name", r.config.NIP11.Name, "description", r.config.NIP11.Description)
 
-	if len(r.config.Sync.Relays) > 0 {
-		r.syncer = NewSyncer(r.config.Sync, r.db)
-		r.syncer.OnEventStored = func(event *nostr.Event) {
-			if event.Kind == 14199 {
-				r.acl.ProcessWhitelistEvent(event)
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			if err := l.server.Serve(l.ln); err != nil && err != http.ErrServerClosed {
+				errCh <- err
 			}
+		}()
+	}
+
+	if r.backups != nil && r.config.Backup.Enabled {
+		r.backups.Start(ctx, time.Duration(r.config.Backup.IntervalHours)*time.Hour)
+	}
+	r.disk.Start(ctx)
+
+	// The syncer runs even with no relays so a reload can add some.
+	syncer := NewSyncer(r.live.Load().Sync, r.db)
+	syncer.OnEventStored = func(url string, event *nostr.Event) {
+		r.trackStored(ctx, event)
+		r.firehose.publish(firehoseEntry{Type: firehoseAccepted, Source: sourceSync, Event: event, Relay: url})
+	}
+	// An in-memory store starts empty, so it has nothing to resume from.
+	if r.config.Storage.Backend != storageMemory {
+		if err := syncer.LoadCursors(syncCursorsPath(r.config.DataDir)); err != nil {
+			logSync.Warn("ignoring saved cursors", "err", err)
 		}
-		r.syncer.Start(ctx)
 	}
+	r.mu.Lock()
+	r.syncer = syncer
+	r.mu.Unlock()
+	syncer.SetPaused(r.mode.Load() != modeNormal)
+	syncer.Start(ctx)
 
 	select {
 	case err := <-errCh:
@@ -234,37 +453,83 @@ func (r *Relay) Start(ctx context.Context) error {
 	}
 }
 
-// Shutdown gracefully shuts down the relay
+// Shutdown stops the relay in order: it refuses new EVENTs and connections,
+// stops the syncer and saves its cursors, sends CLOSED and NOTICE to every
+// client before hanging up, waits for in-flight writes and background work,
+// then closes the store. Waiting is bounded by shutdown_timeout_seconds;
+// the store is closed when it runs out either way.
 func (r *Relay) Shutdown() error {
-	log.Println("Shutting down relay...")
+	logRelay.Info("shutting down")
+	r.shuttingDown.Store(true)
 
-	if r.syncer != nil {
-		r.syncer.Stop()
+	timeout := time.Duration(r.live.Load().ShutdownTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	r.mu.RLock()
+	stop, syncer, servers := r.cancel, r.syncer, r.servers
+	r.mu.RUnlock()
+	if stop != nil {
+		stop()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if syncer != nil {
+		waitUntil(ctx, "the syncer", syncer.Stop)
+	}
 
-	if r.server != nil {
-		if err := r.server.Shutdown(ctx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
+	r.firehose.close()
+	if n := r.conns.closeAll(shutdownReason); n > 0 {
+		logRelay.Info("closed client connections", "connections", n)
+	}
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logRelay.Warn("server shutdown failed", "err", err)
 		}
 	}
 
+	waitUntil(ctx, "in-flight writes", r.writes.close())
+	waitUntil(ctx, "whitelist backfills", r.acl.Wait)
+	if r.backups != nil {
+		waitUntil(ctx, "a scheduled snapshot", r.backups.Wait)
+	}
+	waitUntil(ctx, "disk maintenance", r.disk.Wait)
+	waitUntil(ctx, "the search index rebuild and config watcher", r.background.Wait)
+
+	if r.search != nil {
+		r.search.Close()
+	}
+
+	os.Remove(adminTokenPath(r.config.DataDir))
+
 	if r.db != nil {
 		r.db.Close()
 	}
 
-	log.Println("Relay shutdown complete")
+	logRelay.Info("shutdown complete")
 	return nil
 }
 
+// trackStored keeps the whitelist, search index and quotas up to date for
+// an event stored without going through khatru, by the syncer or an import.
+func (r *Relay) trackStored(ctx context.Context, event *nostr.Event) {
+	if event.Kind == 14199 {
+		r.acl.ProcessWhitelistEvent(event)
+	}
+	if r.search != nil {
+		r.search.Index(ctx, event)
+	}
+	if r.quota != nil {
+		r.quota.track(event, 1)
+	}
+}
+
 func (r *Relay) handleHealth(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status": "healthy",
-		"relay":  r.config.NIP11.Name,
+		"relay":  r.live.Load().NIP11.Name,
+		"mode":   r.mode.Load(),
 	})
 }
 
@@ -297,6 +562,10 @@ func shouldApplyDefaultTimeWindow(filter nostr.Filter) bool {
 	if filter.Since != nil || filter.Until != nil {
 		return false
 	}
+	// Searches are ranked by relevance across all history.
+	if filter.Search != "" {
+		return false
+	}
 	if isReplaceableDiscoveryFilter(filter) {
 		return false
 	}
@@ -313,6 +582,9 @@ func isBroadHistoricalFilter(filter nostr.Filter) bool {
 	if filter.Since != nil || filter.Until != nil {
 		return false
 	}
+	if filter.Search != "" {
+		return false
+	}
 	return true
 }
 
@@ -347,6 +619,13 @@ func newHistoricalQueryReplayGuard(window time.Duration) *historicalQueryReplayG
 	}
 }
 
+// setWindow changes the replay window, e.g. on config reload.
+func (g *historicalQueryReplayGuard) setWindow(window time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.window = window
+}
+
 func (g *historicalQueryReplayGuard) Apply(ctx context.Context, filter *nostr.Filter) {
 	if g == nil || filter == nil || filter.LimitZero || len(filter.IDs) > 0 {
 		return
@@ -366,6 +645,10 @@ func (g *historicalQueryReplayGuard) Apply(ctx context.Context, filter *nostr.Fi
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
+	if g.window <= 0 {
+		return
+	}
+
 	for k, seenAt := range g.lastSeen {
 		if now.Sub(seenAt) > g.window {
 			delete(g.lastSeen, k)
@@ -374,7 +657,7 @@ func (g *historicalQueryReplayGuard) Apply(ctx context.Context, filter *nostr.Fi
 
 	if seenAt, ok := g.lastSeen[key]; ok && now.Sub(seenAt) <= g.window {
 		filter.LimitZero = true
-		log.Printf("[relay] skipped duplicate historical replay ip=%s filter=%s", ip, filter.String())
+		logRelay.Debug("skipped duplicate historical replay", "ip", ip, "filter", filter.String())
 		return
 	}
 
@@ -456,7 +739,7 @@ func instrumentQueryEvents(
 
 			duration := time.Since(start)
 			if duration >= 250*time.Millisecond || count >= 100 {
-				log.Printf("[relay] historical query ip=%s sub=%s count=%d duration=%s filter=%s", ip, subID, count, duration.Round(time.Millisecond), filter.String())
+				logRelay.Info("historical query", "ip", ip, "sub_id", subID, "count", count, "duration", duration.Round(time.Millisecond), "filter", filter.String())
 			}
 		}()
 
@@ -464,19 +747,6 @@ func instrumentQueryEvents(
 	}
 }
 
-func logRejectedEventWrite(ctx context.Context, event *nostr.Event, reason string) {
-	eventID := truncateForLog(event.ID, 12)
-	pubkey := truncateForLog(event.PubKey, 12)
-	ip := khatru.GetIP(ctx)
-	if ip == "" {
-		ip = "unknown"
-	}
-	if reason == "" {
-		reason = "blocked: no reason provided"
-	}
-	log.Printf("[relay] rejected EVENT id=%s kind=%d pubkey=%s ip=%s reason=%s", eventID, event.Kind, pubkey, ip, reason)
-}
-
 func truncateForLog(value string, max int) string {
 	if value == "" {
 		return "unknown"
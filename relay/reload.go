@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// configPollInterval is how often WatchConfig checks the config file.
+const configPollInterval = 2 * time.Second
+
+// liveConfig holds the configuration currently in force. Settings that can
+// change without a restart are read from it on every use; Reload swaps it.
+type liveConfig struct {
+	config atomic.Pointer[Config]
+	exempt atomic.Pointer[rateLimitExemptions]
+}
+
+func newLiveConfig(config *Config) *liveConfig {
+	l := &liveConfig{}
+	l.store(config)
+	return l
+}
+
+func (l *liveConfig) store(config *Config) {
+	l.exempt.Store(newRateLimitExemptions(config.Limits))
+	l.config.Store(config)
+}
+
+func (l *liveConfig) Load() *Config {
+	return l.config.Load()
+}
+
+func (l *liveConfig) Limits() LimitsConfig {
+	return l.config.Load().Limits
+}
+
+func (l *liveConfig) Exempt() *rateLimitExemptions {
+	return l.exempt.Load()
+}
+
+// restartRequired lists the settings that differ between two configs but
+// are only read at startup.
+func restartRequired(prev, next *Config) []string {
+	var changed []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	check("port", prev.Port, next.Port)
+	check("bind_address", prev.BindAddress, next.BindAddress)
+	check("data_dir", prev.DataDir, next.DataDir)
+	check("tls", prev.TLS, next.TLS)
+	check("unix_socket", prev.UnixSocket, next.UnixSocket)
+	check("listeners", listenerBindings(prev), listenerBindings(next))
+	check("storage", prev.Storage, next.Storage)
+	check("search", prev.Search, next.Search)
+	check("backup", prev.Backup, next.Backup)
+	check("disk", prev.Disk, next.Disk)
+	check("quota", prev.Quota, next.Quota)
+	check("limits.max_message_length", prev.Limits.MaxMessageLength, next.Limits.MaxMessageLength)
+	check("log", logOutput(prev), logOutput(next))
+	return changed
+}
+
+// logOutput strips the levels from the log config, leaving where and how
+// logs are written; levels reload in place.
+func logOutput(c *Config) LogConfig {
+	l := c.Log
+	l.Level, l.Components = "", ""
+	return l
+}
+
+// listenerBindings strips the policies from the extra listeners, leaving
+// what they bind to; policies reload in place.
+func listenerBindings(c *Config) []ListenerConfig {
+	bindings := make([]ListenerConfig, len(c.Listeners))
+	for i, l := range c.Listeners {
+		l.Policy = ListenerPolicy{}
+		bindings[i] = l
+	}
+	return bindings
+}
+
+// Reload applies a validated config in place: limits, listener policies,
+// admin pubkeys, log levels and NIP-11 info take effect immediately, and
+// only the sync workers whose relay or kinds changed are restarted.
+// Settings that need a restart keep their current values and are logged.
+func (r *Relay) Reload(next *Config) {
+	prev := r.live.Load()
+
+	applied := *next
+	for _, name := range restartRequired(prev, next) {
+		logRelay.Warn("setting changed; restart the relay to apply it", "setting", name)
+	}
+	applied.Port = prev.Port
+	applied.BindAddress = prev.BindAddress
+	applied.DataDir = prev.DataDir
+	applied.TLS = prev.TLS
+	applied.UnixSocket = prev.UnixSocket
+	if !reflect.DeepEqual(listenerBindings(prev), listenerBindings(next)) {
+		applied.Listeners = prev.Listeners
+	}
+	applied.Storage = prev.Storage
+	applied.Search = prev.Search
+	applied.Backup = prev.Backup
+	applied.Disk = prev.Disk
+	applied.Quota = prev.Quota
+	applied.Limits.MaxMessageLength = prev.Limits.MaxMessageLength
+	applied.Log.Format = prev.Log.Format
+	applied.Log.File = prev.Log.File
+	applied.Log.MaxSizeMB = prev.Log.MaxSizeMB
+	applied.Log.MaxBackups = prev.Log.MaxBackups
+
+	r.live.store(&applied)
+	r.ephemeral.setTTL(time.Duration(applied.Limits.EphemeralRetentionSeconds) * time.Second)
+	r.replayGuard.setWindow(time.Duration(applied.Limits.HistoricalReplayWindowSeconds) * time.Second)
+
+	if applied.Log.Level != prev.Log.Level || applied.Log.Components != prev.Log.Components {
+		logs.setLevels(applied.Log)
+	}
+
+	if !slices.Equal(prev.AdminPubkeys, applied.AdminPubkeys) {
+		r.acl.SetAdmins(applied.AdminPubkeys)
+	}
+
+	r.mu.RLock()
+	syncer := r.syncer
+	r.mu.RUnlock()
+	if syncer != nil {
+		syncer.Update(applied.Sync)
+	}
+
+	if applied.Mode != prev.Mode {
+		r.SetMode(applied.Mode, "config")
+	}
+
+	logRelay.Info("configuration reloaded")
+}
+
+// ReloadConfig loads and validates the config with load, keeping the
+// current settings if that fails.
+func (r *Relay) ReloadConfig(load func() (*Config, error)) {
+	next, err := load()
+	if err != nil {
+		logRelay.Error("config reload failed, keeping current settings", "err", err)
+		return
+	}
+	r.Reload(next)
+}
+
+// WatchConfig polls the config file and reloads it whenever it changes,
+// until ctx is canceled or the relay shuts down. Shutdown waits for the
+// watcher, so a reload never runs against a closed store.
+func (r *Relay) WatchConfig(ctx context.Context, path string, load func() (*Config, error)) {
+	path = expandPath(path)
+	modTime := func() time.Time {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	last := modTime()
+
+	ticker := time.NewTicker(configPollInterval)
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if r.shuttingDown.Load() {
+					return
+				}
+				if mtime := modTime(); !mtime.Equal(last) {
+					last = mtime
+					if mtime.IsZero() {
+						continue // removed, or mid-replace; LoadConfig would fall back to defaults
+					}
+					logRelay.Info("config file changed, reloading", "path", path)
+					r.ReloadConfig(load)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReloadAppliesSettingsInPlace(t *testing.T) {
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Storage.Backend = storageMemory
+	config.Sync.Relays = nil
+
+	relay, err := NewRelay(config)
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	defer relay.db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	syncer := NewSyncer(config.Sync, relay.db)
+	syncer.Start(ctx)
+	defer syncer.Stop()
+	relay.syncer = syncer
+
+	admin := strings.Repeat("a", 64)
+	next := *config
+	next.Port = config.Port + 1
+	next.NIP11.Name = "Renamed"
+	next.AdminPubkeys = []string{admin}
+	next.Limits.MaxContentLength = 10
+	next.Sync = SyncConfig{Relays: []string{"ws://127.0.0.1:1"}, Kinds: []int{1}}
+	relay.Reload(&next)
+
+	live := relay.live.Load()
+	if live.NIP11.Name != "Renamed" || live.Limits.MaxContentLength != 10 {
+		t.Fatalf("expected NIP-11 and limits to be applied, got %q %d", live.NIP11.Name, live.Limits.MaxContentLength)
+	}
+	if live.Port != config.Port {
+		t.Fatalf("expected port to keep its startup value until a restart, got %d", live.Port)
+	}
+	if !relay.acl.IsAdmin(admin) {
+		t.Fatal("expected the new admin to be applied")
+	}
+
+	evt := &nostr.Event{Kind: 1, PubKey: strings.Repeat("b", 64), Content: "longer than ten bytes"}
+	reject := false
+	for _, fn := range relay.khatru.RejectEvent {
+		if r, _ := fn(ctx, evt); r {
+			reject = true
+		}
+	}
+	if !reject {
+		t.Fatal("expected the reloaded content limit to reject the event")
+	}
+
+	if _, ok := syncer.Stats()["relay_status"].(map[string]interface{})["ws://127.0.0.1:1"]; !ok {
+		t.Fatalf("expected a sync worker for the added relay, got %v", syncer.Stats())
+	}
+
+	next.Sync.Relays = nil
+	relay.Reload(&next)
+	if statuses := syncer.Stats()["relay_status"].(map[string]interface{}); len(statuses) != 0 {
+		t.Fatalf("expected the removed relay's worker to stop, got %v", statuses)
+	}
+}
+
+func TestWatchConfigReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "relay.json")
+	config := DefaultConfig()
+	config.DataDir = filepath.Join(dir, "data")
+	config.Storage.Backend = storageMemory
+	config.Sync.Relays = nil
+	if err := writeTestConfig(path, config); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	relay, err := NewRelay(config)
+	if err != nil {
+		t.Fatalf("failed to create relay: %v", err)
+	}
+	defer relay.db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay.WatchConfig(ctx, path, func() (*Config, error) { return LoadConfig(path) })
+
+	changed := *config
+	changed.NIP11.SupportedNIPs = []int{1, 11}
+	changed.Limits.MaxSubscriptions = 7
+	if err := writeTestConfig(path, &changed); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	// Make sure the mtime moves even on coarse-grained filesystems.
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+
+	deadline := time.Now().Add(3 * configPollInterval)
+	for relay.live.Limits().MaxSubscriptions != 7 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the config change to be picked up")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !slices.Equal(relay.live.Load().NIP11.SupportedNIPs, []int{1, 11}) {
+		t.Fatalf("unexpected supported NIPs %v", relay.live.Load().NIP11.SupportedNIPs)
+	}
+
+	// The watcher counts as background work, which Shutdown waits for.
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		relay.background.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected the watcher to stop once its context is canceled")
+	}
+}
+
+func writeTestConfig(path string, config *Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
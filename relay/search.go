@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	evbluge "github.com/fiatjaf/eventstore/bluge"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// searchIndex keeps a full-text index of the configured kinds next to the
+// primary store and answers NIP-50 search filters from it. The index only
+// holds event IDs; matching events are always loaded from the primary store,
+// so stale entries for deleted or replaced events simply never resolve.
+type searchIndex struct {
+	path      string
+	kindsPath string
+	kinds     []int
+	indexed   map[int]bool
+	storage   eventstore.Store
+	backend   *evbluge.BlugeBackend
+
+	// needsRebuild is set when the index was missing or built for a
+	// different set of kinds and must be repopulated from storage.
+	needsRebuild bool
+}
+
+func newSearchIndex(config SearchConfig, dataDir string, storage eventstore.Store) (*searchIndex, error) {
+	kinds := append([]int(nil), config.Kinds...)
+	sort.Ints(kinds)
+
+	indexed := make(map[int]bool, len(kinds))
+	for _, k := range kinds {
+		indexed[k] = true
+	}
+
+	s := &searchIndex{
+		path:      filepath.Join(dataDir, "search"),
+		kindsPath: filepath.Join(dataDir, "search-kinds.json"),
+		kinds:     kinds,
+		indexed:   indexed,
+		storage:   storage,
+	}
+
+	if !s.builtForCurrentKinds() {
+		if err := os.RemoveAll(s.path); err != nil {
+			return nil, fmt.Errorf("failed to reset search index: %w", err)
+		}
+		s.needsRebuild = true
+	}
+
+	s.backend = &evbluge.BlugeBackend{
+		Path:          s.path,
+		RawEventStore: storage,
+	}
+	if err := s.backend.Init(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// builtForCurrentKinds reports whether the on-disk index exists and was last
+// fully built for the configured kinds.
+func (s *searchIndex) builtForCurrentKinds() bool {
+	if _, err := os.Stat(s.path); err != nil {
+		return false
+	}
+	data, err := os.ReadFile(s.kindsPath)
+	if err != nil {
+		return false
+	}
+	var built []int
+	if err := json.Unmarshal(data, &built); err != nil {
+		return false
+	}
+	sort.Ints(built)
+	if len(built) != len(s.kinds) {
+		return false
+	}
+	for i := range built {
+		if built[i] != s.kinds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *searchIndex) shouldIndex(event *nostr.Event) bool {
+	// the index keys authors by the tail of a full hex pubkey
+	return s.indexed[event.Kind] && len(event.PubKey) == 64
+}
+
+// Index adds an event to the index if its kind is searchable.
+func (s *searchIndex) Index(ctx context.Context, event *nostr.Event) {
+	if !s.shouldIndex(event) {
+		return
+	}
+	if err := s.backend.SaveEvent(ctx, event); err != nil {
+		logSearch.Warn("failed to index event", "event_id", event.ID, "kind", event.Kind, "err", err)
+	}
+}
+
+// DeleteEvent removes an event from the index.
+func (s *searchIndex) DeleteEvent(ctx context.Context, event *nostr.Event) error {
+	if !s.indexed[event.Kind] {
+		return nil
+	}
+	return s.backend.DeleteEvent(ctx, event)
+}
+
+// QueryEvents answers filters carrying a NIP-50 search term; everything else
+// is left to the primary store.
+func (s *searchIndex) QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+	if filter.Search == "" || filter.LimitZero || !s.filterCanMatch(filter) {
+		out := make(chan *nostr.Event)
+		close(out)
+		return out, nil
+	}
+
+	ch, err := s.backend.QueryEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *nostr.Event)
+	go func() {
+		defer close(out)
+		for evt := range ch {
+			// The index only understands kinds, authors and time bounds;
+			// ids and tags are checked against the loaded event.
+			if !filter.Matches(evt) {
+				continue
+			}
+			select {
+			case out <- evt:
+			case <-ctx.Done():
+				go func() {
+					for range ch {
+					}
+				}()
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// RejectFilter replaces policies.NoSearchQueries when search is enabled,
+// turning away searches that could never match an indexed kind.
+func (s *searchIndex) RejectFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	if filter.Search == "" {
+		return false, ""
+	}
+	if !s.filterCanMatch(filter) {
+		return true, fmt.Sprintf("unsupported: search is only available for kinds %s", formatKinds(s.kinds))
+	}
+	return false, ""
+}
+
+func (s *searchIndex) filterCanMatch(filter nostr.Filter) bool {
+	if len(filter.Kinds) == 0 {
+		return true
+	}
+	for _, k := range filter.Kinds {
+		if s.indexed[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// Rebuild indexes every stored event of the searchable kinds and records the
+// kind set so the next start can skip the rebuild.
+func (s *searchIndex) Rebuild(ctx context.Context) error {
+	if len(s.kinds) == 0 {
+		return s.writeKinds()
+	}
+
+	start := time.Now()
+	count := 0
+	err := scanEvents(ctx, s.storage, nostr.Filter{Kinds: s.kinds}, func(evt *nostr.Event) error {
+		if !s.shouldIndex(evt) {
+			return nil
+		}
+		if err := s.backend.SaveEvent(ctx, evt); err != nil {
+			return fmt.Errorf("failed to index %s: %w", evt.ID, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logSearch.Info("indexed events", "events", count, "kinds", formatKinds(s.kinds), "duration", time.Since(start).Round(time.Millisecond))
+	s.needsRebuild = false
+	return s.writeKinds()
+}
+
+func (s *searchIndex) writeKinds() error {
+	data, err := json.Marshal(s.kinds)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.kindsPath, data, 0644)
+}
+
+func (s *searchIndex) Close() {
+	s.backend.Close()
+}
+
+// resetSearchIndex forgets which kinds the index was built for, so the next
+// newSearchIndex discards it and asks for a rebuild.
+func resetSearchIndex(dataDir string) error {
+	if err := os.Remove(filepath.Join(dataDir, "search-kinds.json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func formatKinds(kinds []int) string {
+	parts := make([]string, len(kinds))
+	for i, k := range kinds {
+		parts[i] = fmt.Sprintf("%d", k)
+	}
+	return strings.Join(parts, ",")
+}
+
+// runSearchReindex discards the search index and rebuilds it from storage.
+// The relay must not be running, since it holds the storage lock.
+func runSearchReindex(config *Config) error {
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s storage: %w", config.Storage.Backend, err)
+	}
+	defer db.Close()
+
+	if err := resetSearchIndex(config.DataDir); err != nil {
+		return err
+	}
+
+	search, err := newSearchIndex(config.Search, config.DataDir, db)
+	if err != nil {
+		return fmt.Errorf("failed to open search index: %w", err)
+	}
+	defer search.Close()
+
+	return search.Rebuild(context.Background())
+}
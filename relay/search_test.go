@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSearchIndexQueriesIndexedKinds(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+
+	storage, err := openBadger(filepath.Join(dataDir, "badger"))
+	if err != nil {
+		t.Fatalf("failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+
+	search, err := newSearchIndex(SearchConfig{Enabled: true, Kinds: []int{1, 30023}}, dataDir, storage)
+	if err != nil {
+		t.Fatalf("failed to initialize search index: %v", err)
+	}
+	defer search.Close()
+
+	now := nostr.Timestamp(time.Now().Unix())
+	events := []*nostr.Event{
+		{ID: strings.Repeat("1", 64), PubKey: strings.Repeat("a", 64), CreatedAt: now, Kind: 1, Tags: nostr.Tags{}, Content: "deploying the orchestrator tonight", Sig: strings.Repeat("c", 128)},
+		{ID: strings.Repeat("2", 64), PubKey: strings.Repeat("a", 64), CreatedAt: now, Kind: 1, Tags: nostr.Tags{}, Content: "lunch plans", Sig: strings.Repeat("c", 128)},
+		{ID: strings.Repeat("3", 64), PubKey: strings.Repeat("a", 64), CreatedAt: now, Kind: 4199, Tags: nostr.Tags{}, Content: "orchestrator agent definition", Sig: strings.Repeat("c", 128)},
+	}
+	for _, evt := range events {
+		if err := storage.SaveEvent(ctx, evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+		search.Index(ctx, evt)
+	}
+
+	ids := collectSearchIDs(t, search, nostr.Filter{Search: "orchestrator"})
+	if len(ids) != 1 || ids[0] != events[0].ID {
+		t.Fatalf("expected only the indexed kind 1 match, got %v", ids)
+	}
+
+	if reject, _ := search.RejectFilter(ctx, nostr.Filter{Search: "orchestrator", Kinds: []int{4199}}); !reject {
+		t.Fatal("expected search over non-indexed kinds to be rejected")
+	}
+
+	if err := search.DeleteEvent(ctx, events[0]); err != nil {
+		t.Fatalf("failed to delete from index: %v", err)
+	}
+	if ids := collectSearchIDs(t, search, nostr.Filter{Search: "orchestrator"}); len(ids) != 0 {
+		t.Fatalf("expected deleted event to drop out of results, got %v", ids)
+	}
+}
+
+func TestSearchIndexRebuildsFromStorage(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+
+	storage, err := openBadger(filepath.Join(dataDir, "badger"))
+	if err != nil {
+		t.Fatalf("failed to initialize storage: %v", err)
+	}
+	defer storage.Close()
+
+	evt := &nostr.Event{
+		ID:        strings.Repeat("4", 64),
+		PubKey:    strings.Repeat("b", 64),
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      30023,
+		Tags:      nostr.Tags{{"d", "notes"}},
+		Content:   "negentropy reconciliation design notes",
+		Sig:       strings.Repeat("c", 128),
+	}
+	if err := storage.SaveEvent(ctx, evt); err != nil {
+		t.Fatalf("failed to save event: %v", err)
+	}
+
+	config := SearchConfig{Enabled: true, Kinds: []int{30023}}
+	search, err := newSearchIndex(config, dataDir, storage)
+	if err != nil {
+		t.Fatalf("failed to initialize search index: %v", err)
+	}
+	if !search.needsRebuild {
+		t.Fatal("expected a fresh index to need a rebuild")
+	}
+	if err := search.Rebuild(ctx); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	if ids := collectSearchIDs(t, search, nostr.Filter{Search: "negentropy"}); len(ids) != 1 {
+		t.Fatalf("expected rebuilt index to find 1 event, got %v", ids)
+	}
+	search.Close()
+
+	search, err = newSearchIndex(config, dataDir, storage)
+	if err != nil {
+		t.Fatalf("failed to reopen search index: %v", err)
+	}
+	defer search.Close()
+	if search.needsRebuild {
+		t.Fatal("expected an up-to-date index not to need a rebuild")
+	}
+}
+
+func collectSearchIDs(t *testing.T, search *searchIndex, filter nostr.Filter) []string {
+	t.Helper()
+
+	ch, err := search.QueryEvents(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("failed to query search index: %v", err)
+	}
+
+	var ids []string
+	for evt := range ch {
+		ids = append(ids, evt.ID)
+	}
+	return ids
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const shutdownReason = "relay is shutting down"
+
+// shutdownWriteTimeout bounds each goodbye message, so a client that stopped
+// reading can't hold up shutdown.
+const shutdownWriteTimeout = time.Second
+
+var errShuttingDown = errors.New("error: " + shutdownReason)
+
+type netConnKey struct{}
+
+// withNetConn keeps a connection's net.Conn on its requests' contexts. khatru
+// hijacks WebSocket connections, so Server.Shutdown never closes them.
+func withNetConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, netConnKey{}, c)
+}
+
+func requestNetConn(req *http.Request) net.Conn {
+	if req == nil {
+		return nil
+	}
+	c, _ := req.Context().Value(netConnKey{}).(net.Conn)
+	return c
+}
+
+// connTracker keeps the open WebSocket connections and their
+// subscriptions, so shutdown can tell clients before hanging up on them and
+// the admin API can list them.
+type connTracker struct {
+	mu     sync.Mutex
+	conns  map[*khatru.WebSocket]*trackedConn
+	nextID uint64
+}
+
+type trackedConn struct {
+	id          uint64
+	conn        net.Conn
+	ip          string
+	listener    string
+	connectedAt time.Time
+	// subs maps each REQ's context to its subscription.
+	subs map[<-chan struct{}]*trackedSub
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[*khatru.WebSocket]*trackedConn)}
+}
+
+func (t *connTracker) OnConnect(ctx context.Context) {
+	ws := khatru.GetConnection(ctx)
+	if ws == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	t.conns[ws] = &trackedConn{
+		id:          t.nextID,
+		conn:        requestNetConn(ws.Request),
+		ip:          khatru.GetIP(ctx),
+		listener:    requestListener(ws.Request),
+		connectedAt: time.Now(),
+		subs:        make(map[<-chan struct{}]*trackedSub),
+	}
+}
+
+func (t *connTracker) OnDisconnect(ctx context.Context) {
+	ws := khatru.GetConnection(ctx)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, ws)
+}
+
+// OverwriteFilter records the REQ's subscription and its filters, as the
+// client sent them, until it is closed. It leaves the filter alone.
+func (t *connTracker) OverwriteFilter(ctx context.Context, filter *nostr.Filter) {
+	ws := khatru.GetConnection(ctx)
+	if ws == nil || eventstore.IsNegentropySession(ctx) {
+		return
+	}
+	key := ctx.Done()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.conns[ws]
+	if c == nil {
+		return
+	}
+	if sub, ok := c.subs[key]; ok {
+		sub.filters = append(sub.filters, *filter) // another filter of the same REQ
+		return
+	}
+	c.subs[key] = &trackedSub{id: khatru.GetSubscriptionID(ctx), filters: []nostr.Filter{*filter}, openedAt: time.Now()}
+	context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(c.subs, key)
+	})
+}
+
+// closeAll sends CLOSED for every open subscription, then a NOTICE and a
+// close frame, and drops every connection. It returns how many there were.
+func (t *connTracker) closeAll(reason string) int {
+	return t.closeWhere(func(*khatru.WebSocket) bool { return true }, "error: "+reason)
+}
+
+// closeWhere says goodbye to the connections match selects, like closeAll.
+// reason is sent as is, so it should carry a prefix such as "blocked: ".
+func (t *connTracker) closeWhere(match func(*khatru.WebSocket) bool, reason string) int {
+	type goodbye struct {
+		ws   *khatru.WebSocket
+		conn net.Conn
+		subs []string
+	}
+	t.mu.Lock()
+	all := make([]goodbye, 0, len(t.conns))
+	for ws, c := range t.conns {
+		if !match(ws) {
+			continue
+		}
+		g := goodbye{ws: ws, conn: c.conn}
+		for _, sub := range c.subs {
+			if !sub.closed {
+				g.subs = append(g.subs, sub.id)
+			}
+		}
+		all = append(all, g)
+	}
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, g := range all {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.conn != nil {
+				g.conn.SetWriteDeadline(time.Now().Add(shutdownWriteTimeout))
+			}
+			for _, id := range g.subs {
+				g.ws.WriteJSON(nostr.ClosedEnvelope{SubscriptionID: id, Reason: reason})
+			}
+			g.ws.WriteJSON(nostr.NoticeEnvelope(reason))
+			g.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, reason))
+			if g.conn != nil {
+				g.conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	return len(all)
+}
+
+// writeTracker counts store writes in progress so shutdown can wait for
+// them, and refuses new ones once closed.
+type writeTracker struct {
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func (w *writeTracker) begin() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return false
+	}
+	w.wg.Add(1)
+	return true
+}
+
+// close refuses new writes and returns a function waiting for the rest.
+func (w *writeTracker) close() func() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	return w.wg.Wait
+}
+
+func (w *writeTracker) wrap(fn func(context.Context, *nostr.Event) error) func(context.Context, *nostr.Event) error {
+	return func(ctx context.Context, event *nostr.Event) error {
+		if !w.begin() {
+			return errShuttingDown
+		}
+		defer w.wg.Done()
+		return fn(ctx, event)
+	}
+}
+
+func (w *writeTracker) wrapHook(fn func(context.Context, *nostr.Event)) func(context.Context, *nostr.Event) {
+	return func(ctx context.Context, event *nostr.Event) {
+		if !w.begin() {
+			return
+		}
+		defer w.wg.Done()
+		fn(ctx, event)
+	}
+}
+
+// track routes every khatru hook that writes to the store, or follows a
+// write, through w.
+func (w *writeTracker) track(relay *khatru.Relay) {
+	for _, hooks := range []*[]func(context.Context, *nostr.Event) error{&relay.StoreEvent, &relay.ReplaceEvent, &relay.DeleteEvent} {
+		for i, fn := range *hooks {
+			(*hooks)[i] = w.wrap(fn)
+		}
+	}
+	for i, fn := range relay.OnEventSaved {
+		relay.OnEventSaved[i] = w.wrapHook(fn)
+	}
+}
+
+// waitUntil runs wait, giving up when ctx expires. what names it for the log.
+func waitUntil(ctx context.Context, what string, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		logRelay.Warn("shutdown deadline passed", "waiting_for", what)
+		return false
+	}
+}
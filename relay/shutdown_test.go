@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestShutdownClosesSubscriptions(t *testing.T) {
+	relay, url := startTestRelay(t, nil)
+	client := dialTestRelay(t, url)
+	if reason := subscribeTestRelay(t, client, "feed", nostr.Filter{Kinds: []int{1}}); reason != "" {
+		t.Fatalf("unexpected CLOSED: %s", reason)
+	}
+
+	relay.mu.RLock()
+	stop := relay.cancel
+	relay.mu.RUnlock()
+	stop()
+	closed, ok := nextForSubscription(t, client, "feed").(*nostr.ClosedEnvelope)
+	if !ok || !strings.Contains(closed.Reason, shutdownReason) {
+		t.Fatalf("expected CLOSED for the open subscription on shutdown, got %v", closed)
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	evt := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "late"}
+	evt.Sign(sk)
+	for _, fn := range relay.khatru.RejectEvent {
+		if reject, msg := fn(context.Background(), evt); reject {
+			if !strings.Contains(msg, shutdownReason) {
+				t.Fatalf("unexpected rejection %q", msg)
+			}
+			return
+		}
+	}
+	t.Fatal("expected EVENTs to be rejected after shutdown")
+}
+
+func TestSyncCursorsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync-cursors.json")
+	kinds := []int{1, 14199}
+
+	syncer := NewSyncer(SyncConfig{Kinds: kinds}, &memoryStore{})
+	if err := syncer.LoadCursors(path); err != nil {
+		t.Fatalf("failed to load missing cursors: %v", err)
+	}
+	syncer.advanceCursor("wss://a.example", kinds, 1000)
+	syncer.advanceCursor("wss://a.example", kinds, 900)
+	syncer.Stop()
+
+	reloaded := NewSyncer(SyncConfig{Kinds: kinds}, &memoryStore{})
+	if err := reloaded.LoadCursors(path); err != nil {
+		t.Fatalf("failed to load cursors: %v", err)
+	}
+	if got := reloaded.cursor("wss://a.example", kinds); got != 1000 {
+		t.Fatalf("expected the cursor to resume at 1000, got %d", got)
+	}
+	if got := reloaded.cursor("wss://a.example", []int{1}); got != 0 {
+		t.Fatalf("expected no cursor once the kinds change, got %d", got)
+	}
+	if got := reloaded.cursor("wss://b.example", kinds); got != 0 {
+		t.Fatalf("expected no cursor for an unknown relay, got %d", got)
+	}
+}
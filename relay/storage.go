@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/fiatjaf/eventstore"
+	evbadger "github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Supported values for storage.backend
+const (
+	storageBadger = "badger"
+	storageLMDB   = "lmdb"
+	storageSQLite = "sqlite"
+	storageMemory = "memory"
+)
+
+// storeQueryLimit is the per-query cap for the SQLite and memory backends.
+// SQLite's default is lower than scanPageSize. Badger and LMDB are left at
+// their defaults (1000 and 1500) since setting MaxLimit also caps negentropy.
+const storeQueryLimit = 1000
+
+func isKnownStorageBackend(backend string) bool {
+	switch backend {
+	case storageBadger, storageLMDB, storageSQLite, storageMemory:
+		return true
+	}
+	return false
+}
+
+// resolvedPath returns where the backend keeps its files, defaulting to a
+// backend-specific location under the data directory.
+func (s StorageConfig) resolvedPath(dataDir string) string {
+	if s.Path != "" {
+		return expandPath(s.Path)
+	}
+	switch s.Backend {
+	case storageSQLite:
+		return filepath.Join(dataDir, "events.sqlite")
+	case storageMemory:
+		return ""
+	default:
+		return filepath.Join(dataDir, s.Backend)
+	}
+}
+
+// openStore initializes the event store selected by the storage config.
+func openStore(storage StorageConfig, dataDir string) (eventstore.Store, error) {
+	path := storage.resolvedPath(dataDir)
+
+	switch storage.Backend {
+	case storageBadger:
+		return openBadger(path)
+	case storageLMDB:
+		return openLMDB(path)
+	case storageSQLite:
+		return openSQLite(path)
+	case storageMemory:
+		db := &memoryStore{}
+		if err := db.Init(); err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", storage.Backend)
+	}
+}
+
+// openBadger opens a Badger store at path.
+func openBadger(path string) (*evbadger.BadgerBackend, error) {
+	db := &evbadger.BadgerBackend{
+		Path:                  path,
+		BadgerOptionsModifier: silentBadger,
+	}
+	if err := db.Init(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// saveEvent stores an event, using ReplaceEvent for replaceable and
+// addressable kinds so older versions are dropped.
+func saveEvent(ctx context.Context, store eventstore.Store, event *nostr.Event) error {
+	if nostr.IsReplaceableKind(event.Kind) || nostr.IsAddressableKind(event.Kind) {
+		return store.ReplaceEvent(ctx, event)
+	}
+	return store.SaveEvent(ctx, event)
+}
+
+// scanPageSize is how many events scanEvents requests per query. It must stay
+// below the store's MaxLimit so pages are never silently truncated.
+const scanPageSize = 500
+
+// scanEvents walks every stored event matching filter, newest first, calling fn
+// for each one. Stores cap how many events a single query returns, so the scan
+// pages backwards through created_at, deduplicating events that share the
+// timestamp at a page boundary. Returning an error from fn stops the scan.
+func scanEvents(ctx context.Context, store eventstore.Store, filter nostr.Filter, fn func(*nostr.Event) error) error {
+	filter.Limit = scanPageSize
+	filter.LimitZero = false
+
+	// IDs already visited at the current until timestamp
+	seen := make(map[string]bool)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ch, err := store.QueryEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		count, fresh := 0, 0
+		var oldest nostr.Timestamp
+		var oldestIDs []string
+		var fnErr error
+		for evt := range ch {
+			if fnErr != nil {
+				continue // drain so the store's goroutine can exit
+			}
+			count++
+			if count == 1 || evt.CreatedAt < oldest {
+				oldest = evt.CreatedAt
+				oldestIDs = oldestIDs[:0]
+			}
+			if evt.CreatedAt == oldest {
+				oldestIDs = append(oldestIDs, evt.ID)
+			}
+			if seen[evt.ID] {
+				continue
+			}
+			fresh++
+			fnErr = fn(evt)
+		}
+		if fnErr != nil {
+			return fnErr
+		}
+
+		if count < scanPageSize {
+			return nil
+		}
+
+		if fresh == 0 {
+			// A full page of already-visited events means more than a page
+			// shares one timestamp; step past it rather than loop forever.
+			if oldest == 0 {
+				return nil
+			}
+			until := oldest - 1
+			filter.Until = &until
+			seen = make(map[string]bool)
+			continue
+		}
+
+		if filter.Until == nil || *filter.Until != oldest {
+			seen = make(map[string]bool)
+		}
+		for _, id := range oldestIDs {
+			seen[id] = true
+		}
+		until := oldest
+		filter.Until = &until
+	}
+}
+
+// runStorageMigrate copies every event from another backend into the
+// configured one. The relay must not be running.
+// Usage: tenex-relay migrate-storage <from-backend> [from-path]
+func runStorageMigrate(config *Config, fromBackend, fromPath string) error {
+	from := StorageConfig{Backend: fromBackend, Path: fromPath}
+	if !isKnownStorageBackend(from.Backend) {
+		return fmt.Errorf("unknown storage backend %q", from.Backend)
+	}
+	if from.Backend == storageMemory {
+		return fmt.Errorf("cannot migrate from the memory backend: it holds no data between runs")
+	}
+	if config.Storage.Backend == storageMemory {
+		return fmt.Errorf("cannot migrate into the memory backend: it holds no data between runs")
+	}
+
+	fromPath = from.resolvedPath(config.DataDir)
+	toPath := config.Storage.resolvedPath(config.DataDir)
+	if from.Backend == config.Storage.Backend && fromPath == toPath {
+		return fmt.Errorf("source and destination are the same %s store at %s", from.Backend, toPath)
+	}
+
+	log.Printf("Migrating storage %s (%s) → %s (%s)", from.Backend, fromPath, config.Storage.Backend, toPath)
+
+	src, err := openStore(from, config.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open source %s store: %w", from.Backend, err)
+	}
+	defer src.Close()
+
+	if err := config.EnsureDataDir(); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	dst, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open destination %s store: %w", config.Storage.Backend, err)
+	}
+	defer dst.Close()
+
+	ctx := context.Background()
+	copied, skipped := 0, 0
+	err = scanEvents(ctx, src, nostr.Filter{}, func(evt *nostr.Event) error {
+		if err := saveEvent(ctx, dst, evt); err != nil {
+			if err != eventstore.ErrDupEvent {
+				log.Printf("Warning: failed to copy %s: %v", truncateForLog(evt.ID, 12), err)
+			}
+			skipped++
+			return nil
+		}
+		copied++
+		if copied%50000 == 0 {
+			log.Printf("  %d events copied...", copied)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("read error: %w", err)
+	}
+
+	log.Printf("Storage migration complete: %d copied, %d failed/skipped", copied, skipped)
+
+	// Copied events bypass the search index.
+	return resetSearchIndex(config.DataDir)
+}
@@ -0,0 +1,32 @@
+//go:build cgo
+
+package main
+
+import (
+	"github.com/fiatjaf/eventstore"
+	evlmdb "github.com/fiatjaf/eventstore/lmdb"
+	evsqlite "github.com/fiatjaf/eventstore/sqlite3"
+)
+
+// cgoStorage reports whether this build includes the LMDB and SQLite
+// backends.
+const cgoStorage = true
+
+func openLMDB(path string) (eventstore.Store, error) {
+	db := &evlmdb.LMDBBackend{Path: path}
+	if err := db.Init(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func openSQLite(path string) (eventstore.Store, error) {
+	db := &evsqlite.SQLite3Backend{
+		DatabaseURL: path,
+		QueryLimit:  storeQueryLimit,
+	}
+	if err := db.Init(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
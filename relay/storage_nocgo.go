@@ -0,0 +1,21 @@
+//go:build !cgo
+
+package main
+
+import (
+	"errors"
+
+	"github.com/fiatjaf/eventstore"
+)
+
+// The LMDB and SQLite backends wrap C libraries, so release builds made with
+// CGO_ENABLED=0 (see Makefile) only ship Badger and the in-memory store.
+const cgoStorage = false
+
+func openLMDB(path string) (eventstore.Store, error) {
+	return nil, errors.New("the lmdb backend requires a build with CGO_ENABLED=1")
+}
+
+func openSQLite(path string) (eventstore.Store, error) {
+	return nil, errors.New("the sqlite backend requires a build with CGO_ENABLED=1")
+}
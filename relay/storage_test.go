@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestScanEventsVisitsEveryEventAcrossPages(t *testing.T) {
+	ctx := context.Background()
+	store, err := openStore(StorageConfig{Backend: storageMemory}, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	// Several events per second so page boundaries land inside a timestamp.
+	total := scanPageSize*2 + 37
+	for i := 0; i < total; i++ {
+		evt := &nostr.Event{
+			ID:        fmt.Sprintf("%064x", i),
+			PubKey:    fmt.Sprintf("%064x", 1),
+			CreatedAt: nostr.Timestamp(1700000000 + i/7),
+			Kind:      1,
+			Tags:      nostr.Tags{},
+		}
+		if err := store.SaveEvent(ctx, evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	err = scanEvents(ctx, store, nostr.Filter{}, func(evt *nostr.Event) error {
+		if seen[evt.ID] {
+			t.Fatalf("event %s visited twice", evt.ID)
+		}
+		seen[evt.ID] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if len(seen) != total {
+		t.Fatalf("expected %d events, visited %d", total, len(seen))
+	}
+}
+
+func TestStorageMigrateCopiesEvents(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	fromPath := filepath.Join(t.TempDir(), "old-badger")
+
+	src, err := openBadger(fromPath)
+	if err != nil {
+		t.Fatalf("failed to open source: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		evt := &nostr.Event{
+			ID:        strings.Repeat(fmt.Sprint(i+1), 64),
+			PubKey:    fmt.Sprintf("%064x", 1),
+			CreatedAt: nostr.Timestamp(1700000000 + i),
+			Kind:      1,
+			Tags:      nostr.Tags{},
+		}
+		if err := src.SaveEvent(ctx, evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+	}
+	src.Close()
+
+	config := DefaultConfig()
+	config.DataDir = dataDir
+	if err := runStorageMigrate(config, storageBadger, fromPath); err != nil {
+		t.Fatalf("storage migration failed: %v", err)
+	}
+
+	dst, err := openStore(config.Storage, dataDir)
+	if err != nil {
+		t.Fatalf("failed to open destination: %v", err)
+	}
+	defer dst.Close()
+
+	n := 0
+	if err := scanEvents(ctx, dst, nostr.Filter{}, func(*nostr.Event) error { n++; return nil }); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 copied events, got %d", n)
+	}
+
+	if err := runStorageMigrate(config, storageBadger, ""); err == nil {
+		t.Fatal("expected migrating a store onto itself to fail")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// subscriptionLimiter caps open subscriptions per connection and per
+// authenticated pubkey, and filters per REQ.
+//
+// khatru has no hooks for a subscription's lifecycle, so a REQ is identified
+// by its context: every filter in a REQ shares it, and it is cancelled on
+// CLOSE, when a filter is rejected, and when the connection drops.
+type subscriptionLimiter struct {
+	live *liveConfig
+
+	mu        sync.Mutex
+	reqs      map[<-chan struct{}]*subscription
+	perConn   map[*khatru.WebSocket]int
+	perPubkey map[string]int
+}
+
+type subscription struct {
+	ws      *khatru.WebSocket
+	pubkey  string
+	counted bool
+	filters int
+	reason  string // set once the REQ is to be CLOSED
+}
+
+func newSubscriptionLimiter(live *liveConfig) *subscriptionLimiter {
+	return &subscriptionLimiter{
+		live:      live,
+		reqs:      make(map[<-chan struct{}]*subscription),
+		perConn:   make(map[*khatru.WebSocket]int),
+		perPubkey: make(map[string]int),
+	}
+}
+
+// OverwriteFilter counts each REQ and its filters. It must run after every
+// other OverwriteFilter hook: khatru skips RejectFilter for limit:0 filters,
+// so a REQ over the limits has LimitZero cleared to be CLOSED there.
+func (l *subscriptionLimiter) OverwriteFilter(ctx context.Context, filter *nostr.Filter) {
+	ws := khatru.GetConnection(ctx)
+	if ws == nil || eventstore.IsNegentropySession(ctx) || l.live.Policy(requestListener(ws.Request)).Trusted {
+		return
+	}
+	key := ctx.Done()
+	limits := l.live.Limits()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sub := l.reqs[key]
+	if sub == nil {
+		sub = &subscription{ws: ws, pubkey: khatru.GetAuthed(ctx)}
+		if max := limits.MaxSubscriptions; max > 0 && l.perConn[ws] >= max {
+			sub.reason = fmt.Sprintf("blocked: too many subscriptions, max %d per connection", max)
+		} else if max := limits.MaxSubscriptionsPerPubkey; max > 0 && sub.pubkey != "" && l.perPubkey[sub.pubkey] >= max {
+			sub.reason = fmt.Sprintf("blocked: too many subscriptions, max %d per pubkey", max)
+		} else {
+			sub.counted = true
+			l.perConn[ws]++
+			if sub.pubkey != "" {
+				l.perPubkey[sub.pubkey]++
+			}
+		}
+		l.reqs[key] = sub
+		context.AfterFunc(ctx, func() { l.release(key) })
+		if sub.reason != "" {
+			logRelay.Info("closing REQ", "sub_id", khatru.GetSubscriptionID(ctx), "ip", khatru.GetIP(ctx), "reason", sub.reason)
+		}
+	}
+
+	sub.filters++
+	if max := limits.MaxFilters; max > 0 && sub.filters > max && sub.reason == "" {
+		sub.reason = fmt.Sprintf("blocked: too many filters, max %d per REQ", max)
+		logRelay.Info("closing REQ", "sub_id", khatru.GetSubscriptionID(ctx), "ip", khatru.GetIP(ctx), "reason", sub.reason)
+	}
+	if sub.reason != "" {
+		filter.LimitZero = false
+	}
+}
+
+// RejectFilter closes REQs that OverwriteFilter found over the limits.
+func (l *subscriptionLimiter) RejectFilter(ctx context.Context, filter nostr.Filter) (reject bool, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sub := l.reqs[ctx.Done()]; sub != nil && sub.reason != "" {
+		return true, sub.reason
+	}
+	return false, ""
+}
+
+func (l *subscriptionLimiter) release(key <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sub := l.reqs[key]
+	if sub == nil {
+		return
+	}
+	delete(l.reqs, key)
+	if !sub.counted {
+		return
+	}
+	if l.perConn[sub.ws]--; l.perConn[sub.ws] <= 0 {
+		delete(l.perConn, sub.ws)
+	}
+	if sub.pubkey != "" {
+		if l.perPubkey[sub.pubkey]--; l.perPubkey[sub.pubkey] <= 0 {
+			delete(l.perPubkey, sub.pubkey)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSubscriptionLimitsCloseExcessREQs(t *testing.T) {
+	_, url := startTestRelay(t, func(config *Config) {
+		config.Policy = ListenerPolicy{}
+		config.Limits.MaxSubscriptions = 2
+		config.Limits.MaxFilters = 2
+	})
+	client := dialTestRelay(t, url)
+
+	// Ephemeral-only filters don't need NIP-42.
+	ephemeral := nostr.Filter{Kinds: []int{24133}}
+	live := nostr.Filter{Kinds: []int{24133}, LimitZero: true}
+
+	if reason := subscribeTestRelay(t, client, "a", ephemeral, ephemeral, ephemeral); !strings.Contains(reason, "too many filters") {
+		t.Fatalf("expected a REQ over the filter cap to be closed, got %q", reason)
+	}
+	if reason := subscribeTestRelay(t, client, "b", ephemeral); reason != "" {
+		t.Fatalf("unexpected CLOSED: %s", reason)
+	}
+	if reason := subscribeTestRelay(t, client, "c", live); reason != "" {
+		t.Fatalf("unexpected CLOSED: %s", reason)
+	}
+	// limit:0 subscriptions count too.
+	if reason := subscribeTestRelay(t, client, "d", live); !strings.Contains(reason, "too many subscriptions") {
+		t.Fatalf("expected a third subscription to be closed, got %q", reason)
+	}
+
+	// Closing a subscription frees its slot.
+	closeID := nostr.CloseEnvelope("b")
+	if err := client.write(&closeID); err != nil {
+		t.Fatalf("failed to send CLOSE: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reason := subscribeTestRelay(t, client, "e", ephemeral)
+		if reason == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a slot to free up after CLOSE, got %q", reason)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// dialTestRelay connects a plain websocket client that reads frames
+// synchronously; go-nostr's Relay races with itself on close.
+func dialTestRelay(t *testing.T, url string) *relayConn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := dialRelay(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", url, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// nextForSubscription returns the next EVENT, EOSE or CLOSED sent for subID.
+func nextForSubscription(t *testing.T, client *relayConn, subID string) nostr.Envelope {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		env, err := client.read(deadline)
+		if err != nil {
+			t.Fatalf("waiting on subscription %s: %v", subID, err)
+		}
+		switch e := env.(type) {
+		case *nostr.EventEnvelope:
+			if e.SubscriptionID != nil && *e.SubscriptionID == subID {
+				return e
+			}
+		case *nostr.EOSEEnvelope:
+			if string(*e) == subID {
+				return e
+			}
+		case *nostr.ClosedEnvelope:
+			if e.SubscriptionID == subID {
+				return e
+			}
+		}
+	}
+}
+
+// subscribeTestRelay sends a REQ and returns the CLOSED reason, or "" once
+// EOSE arrives. Stored events before EOSE are skipped.
+func subscribeTestRelay(t *testing.T, client *relayConn, subID string, filters ...nostr.Filter) string {
+	t.Helper()
+	if err := client.write(&nostr.ReqEnvelope{SubscriptionID: subID, Filters: filters}); err != nil {
+		t.Fatalf("failed to send REQ: %v", err)
+	}
+	for {
+		switch e := nextForSubscription(t, client, subID).(type) {
+		case *nostr.EOSEEnvelope:
+			return ""
+		case *nostr.ClosedEnvelope:
+			return e.Reason
+		}
+	}
+}
+
+// publishTestRelay sends an EVENT and returns the OK reason; ok is false
+// when the relay refused it.
+func publishTestRelay(t *testing.T, client *relayConn, evt nostr.Event) (ok bool, reason string) {
+	t.Helper()
+	if err := client.write(&nostr.EventEnvelope{Event: evt}); err != nil {
+		t.Fatalf("failed to send EVENT: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		env, err := client.read(deadline)
+		if err != nil {
+			t.Fatalf("waiting for OK: %v", err)
+		}
+		if e, isOK := env.(*nostr.OKEnvelope); isOK && e.EventID == evt.ID {
+			return e.OK, e.Reason
+		}
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -51,12 +54,28 @@ func (s *SyncStats) snapshot() map[string]interface{} {
 
 // Syncer manages event synchronization from remote relays
 type Syncer struct {
-	config        SyncConfig
 	storage       eventstore.Store
 	stats         SyncStats
 	cancel        context.CancelFunc
 	wg            sync.WaitGroup
-	OnEventStored func(*nostr.Event)
+	OnEventStored func(url string, event *nostr.Event)
+
+	// cursorPath is where cursors are persisted; empty keeps them in memory.
+	cursorPath string
+	cursorMu   sync.Mutex
+	cursors    map[string]syncCursor
+
+	mu      sync.Mutex
+	ctx     context.Context
+	config  SyncConfig
+	workers map[string]*syncWorker
+	paused  bool
+}
+
+// syncWorker is the goroutine syncing from one relay.
+type syncWorker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 // NewSyncer creates a new Syncer
@@ -64,6 +83,8 @@ func NewSyncer(config SyncConfig, storage eventstore.Store) *Syncer {
 	return &Syncer{
 		config:  config,
 		storage: storage,
+		workers: make(map[string]*syncWorker),
+		cursors: make(map[string]syncCursor),
 		stats: SyncStats{
 			RelayStatus: make(map[string]RelayStatus),
 		},
@@ -72,39 +93,206 @@ func NewSyncer(config SyncConfig, storage eventstore.Store) *Syncer {
 
 // Start launches a goroutine per sync relay
 func (s *Syncer) Start(ctx context.Context) {
-	ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	if s.paused {
+		logSync.Info("paused until the relay is back in normal mode")
+		return
+	}
 	for _, url := range s.config.Relays {
-		s.stats.mu.Lock()
-		s.stats.RelayStatus[url] = RelayStatus{URL: url}
-		s.stats.mu.Unlock()
+		s.startWorker(url)
+	}
+
+	if len(s.config.Relays) > 0 {
+		logSync.Info("started", "relays", len(s.config.Relays), "kinds", len(s.config.Kinds))
+	}
+}
+
+// Update applies a new sync config, restarting only the workers it
+// affects: changed kinds restart every worker, otherwise removed relays
+// are stopped and added ones started.
+func (s *Syncer) Update(config SyncConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kindsChanged := !slices.Equal(s.config.Kinds, config.Kinds)
+	s.config = config
+	if s.ctx == nil || s.paused {
+		return // Start or SetPaused(false) uses the new config
+	}
+
+	var stopped, started int
+	for url := range s.workers {
+		if kindsChanged || !slices.Contains(config.Relays, url) {
+			s.stopWorker(url)
+			stopped++
+		}
+	}
+	for _, url := range config.Relays {
+		if _, ok := s.workers[url]; !ok {
+			s.startWorker(url)
+			started++
+		}
+	}
+	if stopped > 0 || started > 0 {
+		logSync.Info("reconfigured", "stopped", stopped, "started", started, "relays", len(config.Relays))
+	}
+}
+
+// startWorker must be called with s.mu held.
+func (s *Syncer) startWorker(url string) {
+	if _, ok := s.workers[url]; ok {
+		return
+	}
+	s.stats.mu.Lock()
+	s.stats.RelayStatus[url] = RelayStatus{URL: url}
+	s.stats.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	w := &syncWorker{cancel: cancel, done: make(chan struct{})}
+	s.workers[url] = w
+	kinds := s.config.Kinds
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(w.done)
+		s.syncRelay(ctx, url, kinds)
+	}()
+}
 
-		s.wg.Add(1)
-		go func(relayURL string) {
-			defer s.wg.Done()
-			s.syncRelay(ctx, relayURL)
-		}(url)
+// stopWorker must be called with s.mu held. It waits for the worker to
+// exit so a restarted worker never overlaps the old one.
+func (s *Syncer) stopWorker(url string) {
+	w := s.workers[url]
+	if w == nil {
+		return
 	}
+	w.cancel()
+	<-w.done
+	delete(s.workers, url)
 
-	log.Printf("[sync] started sync for %d relay(s), %d kind(s)", len(s.config.Relays), len(s.config.Kinds))
+	s.stats.mu.Lock()
+	delete(s.stats.RelayStatus, url)
+	s.stats.mu.Unlock()
+}
+
+// SetPaused stops every worker while paused, so nothing is written to the
+// store, and starts them again on resume.
+func (s *Syncer) SetPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paused == paused {
+		return
+	}
+	s.paused = paused
+	if s.ctx == nil {
+		return
+	}
+	if paused {
+		for url := range s.workers {
+			s.stopWorker(url)
+		}
+		logSync.Info("paused")
+		return
+	}
+	for _, url := range s.config.Relays {
+		s.startWorker(url)
+	}
+	logSync.Info("resumed", "relays", len(s.config.Relays))
+}
+
+// Resync forgets the cursor for url, or for every relay if url is empty,
+// and restarts the affected workers so they sync their full history again.
+// It returns the relays being resynced.
+func (s *Syncer) Resync(url string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urls := s.config.Relays
+	if url != "" {
+		if !slices.Contains(s.config.Relays, url) {
+			return nil, fmt.Errorf("%s is not a sync relay", url)
+		}
+		urls = []string{url}
+	}
+
+	// Stop the workers first so they can't advance a cursor once cleared.
+	running := s.ctx != nil && !s.paused
+	if running {
+		for _, u := range urls {
+			s.stopWorker(u)
+		}
+	}
+	s.cursorMu.Lock()
+	for _, u := range urls {
+		delete(s.cursors, u)
+	}
+	s.cursorMu.Unlock()
+	if running {
+		for _, u := range urls {
+			s.startWorker(u)
+		}
+	}
+	logSync.Info("resyncing", "relays", len(urls))
+	return urls, nil
 }
 
-// Stop cancels all sync goroutines and waits for them to finish
+// Stop cancels all sync goroutines, waits for them to finish and persists
+// the cursors they reached.
 func (s *Syncer) Stop() {
+	s.mu.Lock()
 	if s.cancel != nil {
 		s.cancel()
 	}
+	s.mu.Unlock()
 	s.wg.Wait()
-	log.Println("[sync] stopped")
+	if err := s.saveCursors(); err != nil {
+		logSync.Error("failed to save cursors", "err", err)
+	}
+	logSync.Info("stopped")
 }
 
 // Stats returns the current sync stats snapshot
 func (s *Syncer) Stats() map[string]interface{} {
-	return s.stats.snapshot()
+	stats := s.stats.snapshot()
+	s.mu.Lock()
+	stats["paused"] = s.paused
+	s.mu.Unlock()
+
+	// Where each relay would resume from, as a unix timestamp.
+	s.cursorMu.Lock()
+	cursors := make(map[string]nostr.Timestamp, len(s.cursors))
+	for url, c := range s.cursors {
+		cursors[url] = c.Since
+	}
+	s.cursorMu.Unlock()
+	stats["cursors"] = cursors
+	return stats
+}
+
+// Connected counts the sync relays currently connected, and reports
+// whether syncing is paused.
+func (s *Syncer) Connected() (connected int, paused bool) {
+	s.mu.Lock()
+	paused = s.paused
+	s.mu.Unlock()
+
+	s.stats.mu.RLock()
+	defer s.stats.mu.RUnlock()
+	for _, rs := range s.stats.RelayStatus {
+		if rs.Connected {
+			connected++
+		}
+	}
+	return connected, paused
 }
 
 // syncRelay is the reconnection loop for a single relay with exponential backoff
-func (s *Syncer) syncRelay(ctx context.Context, url string) {
+func (s *Syncer) syncRelay(ctx context.Context, url string, kinds []int) {
 	backoff := 5 * time.Second
 	maxBackoff := 5 * time.Minute
 
@@ -115,14 +303,14 @@ func (s *Syncer) syncRelay(ctx context.Context, url string) {
 		default:
 		}
 
-		err := s.runSync(ctx, url)
+		err := s.runSync(ctx, url, kinds)
 		if ctx.Err() != nil {
 			return
 		}
 
 		s.setRelayStatus(url, false, err)
 
-		log.Printf("[sync] %s disconnected (err: %v), reconnecting in %v", url, err, backoff)
+		logSync.Warn("disconnected, reconnecting", "url", url, "err", err, "backoff", backoff)
 
 		select {
 		case <-ctx.Done():
@@ -139,7 +327,7 @@ func (s *Syncer) syncRelay(ctx context.Context, url string) {
 }
 
 // runSync connects to a relay, subscribes to configured kinds, and streams events
-func (s *Syncer) runSync(ctx context.Context, url string) error {
+func (s *Syncer) runSync(ctx context.Context, url string, kinds []int) error {
 	connectCtx, connectCancel := context.WithTimeout(ctx, 10*time.Second)
 	defer connectCancel()
 
@@ -150,12 +338,17 @@ func (s *Syncer) runSync(ctx context.Context, url string) error {
 	defer relay.Close()
 
 	s.setRelayStatus(url, true, nil)
-	log.Printf("[sync] connected to %s", url)
+	logSync.Info("connected", "url", url)
 
-	// Subscribe to configured kinds
+	// Subscribe to configured kinds, resuming from the cursor if there is one
 	filters := nostr.Filters{{
-		Kinds: s.config.Kinds,
+		Kinds: kinds,
 	}}
+	if since := s.cursor(url, kinds); since > syncCursorOverlap {
+		since -= syncCursorOverlap
+		filters[0].Since = &since
+		logSync.Info("resuming from cursor", "url", url, "since", since.Time().UTC())
+	}
 
 	sub, err := relay.Subscribe(ctx, filters)
 	if err != nil {
@@ -171,6 +364,10 @@ func (s *Syncer) runSync(ctx context.Context, url string) error {
 	// Track authors for profile sync after EOSE
 	var authorsMu sync.Mutex
 	authors := make(map[string]struct{})
+	// Stored events may arrive in any order, so the cursor only moves to
+	// the newest of them at EOSE; live events move it as they come.
+	var newest nostr.Timestamp
+	eose := false
 	for {
 		select {
 		case evt, ok := <-sub.Events:
@@ -178,11 +375,18 @@ func (s *Syncer) runSync(ctx context.Context, url string) error {
 				return fmt.Errorf("subscription closed")
 			}
 
-			if err := s.storeEvent(ctx, evt); err != nil {
-				log.Printf("[sync] store error for %s: %v", evt.ID[:12], err)
+			if err := s.storeEvent(ctx, url, evt); err != nil {
+				logSync.Warn("failed to store event", "url", url, "event_id", evt.ID, "kind", evt.Kind, "err", err)
 				continue
 			}
 
+			if evt.CreatedAt > newest {
+				newest = evt.CreatedAt
+				if eose {
+					s.advanceCursor(url, kinds, newest)
+				}
+			}
+
 			atomic.AddInt64(&s.stats.EventsSynced, 1)
 			s.stats.mu.Lock()
 			now := time.Now()
@@ -195,6 +399,9 @@ func (s *Syncer) runSync(ctx context.Context, url string) error {
 			authorsMu.Unlock()
 
 		case <-sub.EndOfStoredEvents:
+			eose = true
+			s.advanceCursor(url, kinds, newest)
+
 			authorsMu.Lock()
 			authorList := make([]string, 0, len(authors))
 			for a := range authors {
@@ -202,13 +409,17 @@ func (s *Syncer) runSync(ctx context.Context, url string) error {
 			}
 			authorsMu.Unlock()
 
-			log.Printf("[sync] EOSE from %s, synced %d events so far, %d unique authors",
-				url, atomic.LoadInt64(&s.stats.EventsSynced), len(authorList))
+			logSync.Info("EOSE", "url", url, "events_synced", atomic.LoadInt64(&s.stats.EventsSynced), "authors", len(authorList))
 
 			// Start one profile refresh loop per connection.
 			if !profileLoopStarted {
 				profileLoopStarted = true
-				go s.profileSyncLoop(profileCtx, relay, &authorsMu, &authors, authorList)
+				// Tracked so Stop waits for profile writes too.
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					s.profileSyncLoop(profileCtx, relay, &authorsMu, &authors, authorList)
+				}()
 			}
 
 		case reason := <-sub.ClosedReason:
@@ -243,7 +454,7 @@ func (s *Syncer) profileSyncLoop(ctx context.Context, relay *nostr.Relay, author
 			authorsMu.Unlock()
 
 			if len(authorList) > 0 {
-				log.Printf("[sync] periodic profile refresh for %d authors", len(authorList))
+				logSync.Info("periodic profile refresh", "authors", len(authorList))
 				s.syncProfiles(ctx, relay, authorList)
 			}
 		}
@@ -252,7 +463,7 @@ func (s *Syncer) profileSyncLoop(ctx context.Context, relay *nostr.Relay, author
 
 // syncProfiles fetches kind:0 profiles for the given authors, replacing any existing ones
 func (s *Syncer) syncProfiles(ctx context.Context, relay *nostr.Relay, authors []string) {
-	log.Printf("[sync] fetching profiles for %d authors", len(authors))
+	logSync.Debug("fetching profiles", "authors", len(authors))
 
 	batchSize := 100
 	for i := 0; i < len(authors); i += batchSize {
@@ -273,31 +484,25 @@ func (s *Syncer) syncProfiles(ctx context.Context, relay *nostr.Relay, authors [
 			Kinds:   []int{0},
 		})
 		if err != nil {
-			log.Printf("[sync] profile batch query failed: %v", err)
+			logSync.Warn("profile batch query failed", "err", err)
 			continue
 		}
 
 		stored := 0
 		for _, evt := range events {
-			if err := s.storeEvent(ctx, evt); err == nil {
+			if err := s.storeEvent(ctx, relay.URL, evt); err == nil {
 				stored++
 			}
 		}
-		log.Printf("[sync] stored %d/%d profiles (batch %d-%d)", stored, len(events), i, end)
+		logSync.Debug("stored profiles", "stored", stored, "received", len(events), "batch_start", i, "batch_end", end)
 	}
 }
 
 // storeEvent saves an event, using ReplaceEvent for replaceable/addressable kinds.
-func (s *Syncer) storeEvent(ctx context.Context, event *nostr.Event) error {
-	var err error
-	if nostr.IsReplaceableKind(event.Kind) || nostr.IsAddressableKind(event.Kind) {
-		err = s.storage.ReplaceEvent(ctx, event)
-	} else {
-		err = s.storage.SaveEvent(ctx, event)
-	}
-
+func (s *Syncer) storeEvent(ctx context.Context, url string, event *nostr.Event) error {
+	err := saveEvent(ctx, s.storage, event)
 	if err == nil && s.OnEventStored != nil {
-		s.OnEventStored(event)
+		s.OnEventStored(url, event)
 	}
 	return err
 }
@@ -312,3 +517,86 @@ func (s *Syncer) setRelayStatus(url string, connected bool, err error) {
 	}
 	s.stats.RelayStatus[url] = status
 }
+
+// syncCursorOverlap is how far before its cursor a relay is re-read, for
+// events that reach it late or carry a skewed created_at.
+const syncCursorOverlap nostr.Timestamp = 10 * 60
+
+// syncCursor is the newest created_at synced from a relay, and the kinds it
+// was synced for: a cursor for other kinds would skip their history.
+type syncCursor struct {
+	Since nostr.Timestamp `json:"since"`
+	Kinds []int           `json:"kinds"`
+}
+
+func syncCursorsPath(dataDir string) string {
+	return filepath.Join(dataDir, "sync-cursors.json")
+}
+
+// LoadCursors reads persisted cursors from path, and saves them there on
+// Stop. Call it before Start.
+func (s *Syncer) LoadCursors(path string) error {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+	s.cursorPath = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cursors := make(map[string]syncCursor)
+	if err := json.Unmarshal(data, &cursors); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	s.cursors = cursors
+	return nil
+}
+
+func (s *Syncer) saveCursors() error {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+	if s.cursorPath == "" || len(s.cursors) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.cursors, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.cursorPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.cursorPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	logSync.Info("saved cursors", "relays", len(s.cursors), "path", s.cursorPath)
+	return nil
+}
+
+// cursor returns where to resume syncing url, or 0 to sync from scratch.
+func (s *Syncer) cursor(url string, kinds []int) nostr.Timestamp {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+	c, ok := s.cursors[url]
+	if !ok || !slices.Equal(c.Kinds, kinds) {
+		return 0
+	}
+	return c.Since
+}
+
+func (s *Syncer) advanceCursor(url string, kinds []int, since nostr.Timestamp) {
+	s.cursorMu.Lock()
+	defer s.cursorMu.Unlock()
+	c := s.cursors[url]
+	if !slices.Equal(c.Kinds, kinds) {
+		c = syncCursor{Kinds: kinds}
+	}
+	if since > c.Since {
+		c.Since = since
+		s.cursors[url] = c
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Self-signed certificates are valid for 825 days, the longest iOS accepts
+// for a user-trusted certificate, and replaced a month before they expire.
+const (
+	selfSignedValidity = 825 * 24 * time.Hour
+	selfSignedRenewal  = 30 * 24 * time.Hour
+)
+
+// files returns the certificate and key the relay serves: the configured
+// ones, or the self-signed pair under the data directory.
+func (t TLSConfig) files(dataDir string) (certFile, keyFile string) {
+	if t.CertFile != "" {
+		return expandPath(t.CertFile), expandPath(t.KeyFile)
+	}
+	dir := filepath.Join(dataDir, "tls")
+	return filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+}
+
+// loadTLSCertificate loads the configured certificate, or the self-signed
+// one, generating it when it is missing, about to expire, or doesn't cover
+// tls.hosts. Interface addresses are only picked up on regeneration, so a
+// laptop changing networks keeps its pinned fingerprint.
+func loadTLSCertificate(config *Config) (tls.Certificate, error) {
+	certFile, keyFile := config.TLS.files(config.DataDir)
+	if config.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		return cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil && selfSignedUsable(cert.Leaf, append([]string{"localhost"}, config.TLS.Hosts...)) {
+		return cert, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logRelay.Warn("replacing unreadable self-signed certificate", "err", err)
+	}
+
+	hosts := selfSignedHosts(config.TLS.Hosts)
+	if err := writeSelfSignedCertificate(certFile, keyFile, hosts); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+	}
+	logRelay.Info("generated self-signed TLS certificate", "path", certFile, "hosts", strings.Join(hosts, ", "))
+	cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// selfSignedHosts lists the names a self-signed certificate should cover:
+// localhost, this machine's hostname and interface addresses, so phones on
+// the LAN can connect by IP, and any configured extras.
+func selfSignedHosts(extra []string) []string {
+	hosts := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+	hosts = append(hosts, extra...)
+	slices.Sort(hosts)
+	return slices.Compact(hosts)
+}
+
+func selfSignedUsable(leaf *x509.Certificate, hosts []string) bool {
+	if leaf == nil || time.Until(leaf.NotAfter) < selfSignedRenewal {
+		return false
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func writeSelfSignedCertificate(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "tenex-relay", Organization: []string{"TENEX"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// certFingerprint formats the SHA-256 fingerprint of a DER certificate the
+// way browsers and openssl show it.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// pinnedTLSConfig trusts only the certificate in certFile, for local
+// tooling talking to a relay that may use a self-signed certificate.
+func pinnedTLSConfig(certFile string) *tls.Config {
+	var pinned []byte
+	if data, err := os.ReadFile(certFile); err == nil {
+		if block, _ := pem.Decode(data); block != nil && block.Type == "CERTIFICATE" {
+			pinned = block.Bytes
+		}
+	}
+	return &tls.Config{
+		// The certificate is checked below against the one on disk instead.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if pinned == nil || len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], pinned) {
+				return fmt.Errorf("relay certificate does not match %s", certFile)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelayServesTLSAndUnixSocket(t *testing.T) {
+	relay, _ := startTestRelay(t, func(config *Config) {
+		config.TLS.Enabled = true
+		config.TLS.Hosts = []string{"relay.lan"}
+		config.UnixSocket = filepath.Join(config.DataDir, "relay.sock")
+		config.Policy = ListenerPolicy{}
+	})
+	config := relay.config
+	if !relayRunning(config) {
+		t.Fatal("expected the relay to answer over TLS with its self-signed certificate")
+	}
+
+	cert, err := loadTLSCertificate(config)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+	if err := cert.Leaf.VerifyHostname("relay.lan"); err != nil {
+		t.Fatalf("expected tls.hosts in the certificate: %v", err)
+	}
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", config.UnixSocket)
+		},
+	}}
+	resp, err := unixClient.Get("http://relay/health")
+	if err != nil {
+		t.Fatalf("failed to reach the relay over the unix socket: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d over the unix socket", resp.StatusCode)
+	}
+}
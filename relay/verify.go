@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore"
+	evbadger "github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Problems reported by verify
+const (
+	verifyDecodeError      = "decode_error"
+	verifyInvalidID        = "invalid_id"
+	verifyInvalidSignature = "invalid_signature"
+	verifyMissingIndex     = "missing_index"
+	verifyDanglingIndex    = "dangling_index"
+	verifyDuplicateRecord  = "duplicate_record"
+	verifyStaleVersion     = "stale_version"
+)
+
+// maxVerifyIssues caps the issues listed in a report; counts stay exact.
+const maxVerifyIssues = 10000
+
+// Key prefixes of the eventstore badger layout. Raw events live under
+// badgerRawPrefix+serial; every index key ends in the event's 4-byte serial.
+const (
+	badgerRawPrefix         byte = 0
+	badgerCreatedAtPrefix   byte = 1
+	badgerIDPrefix          byte = 2
+	badgerKindPrefix        byte = 3
+	badgerLastIndexPrefix   byte = 8
+	badgerSerialLength           = 4
+	badgerIDIndexKeyLength       = 1 + 8 + badgerSerialLength
+	badgerRawEventKeyLength      = 1 + badgerSerialLength
+	badgerKindKeyLength          = 1 + 2 + 4 + badgerSerialLength
+)
+
+type verifyIssue struct {
+	Type   string `json:"type"`
+	ID     string `json:"id,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// verifyReport is the machine-readable result of a verify run.
+type verifyReport struct {
+	Backend   string         `json:"backend"`
+	Events    int            `json:"events"`
+	Counts    map[string]int `json:"counts"`
+	Issues    []verifyIssue  `json:"issues"`
+	Truncated bool           `json:"truncated,omitempty"`
+	Repaired  bool           `json:"repaired"`
+	Duration  string         `json:"duration"`
+}
+
+func (r *verifyReport) add(kind, id, detail string) {
+	r.Counts[kind]++
+	if len(r.Issues) >= maxVerifyIssues {
+		r.Truncated = true
+		return
+	}
+	r.Issues = append(r.Issues, verifyIssue{Type: kind, ID: id, Detail: detail})
+}
+
+func (r *verifyReport) problems() int {
+	n := 0
+	for _, c := range r.Counts {
+		n += c
+	}
+	return n
+}
+
+// checkEvent returns the problem with an event's ID or signature, if any.
+func checkEvent(evt *nostr.Event, skipSignatures bool) string {
+	if !evt.CheckID() {
+		return verifyInvalidID
+	}
+	if !skipSignatures {
+		if ok, _ := evt.CheckSignature(); !ok {
+			return verifyInvalidSignature
+		}
+	}
+	return ""
+}
+
+// versionTracker finds replaceable and addressable events that are
+// superseded by a newer version of the same address but still stored.
+type versionTracker struct {
+	latest map[string]*nostr.Event
+	stale  []*nostr.Event
+}
+
+func newVersionTracker() *versionTracker {
+	return &versionTracker{latest: make(map[string]*nostr.Event)}
+}
+
+func (v *versionTracker) add(evt *nostr.Event) {
+	var address string
+	switch {
+	case nostr.IsReplaceableKind(evt.Kind):
+		address = fmt.Sprintf("%d:%s", evt.Kind, evt.PubKey)
+	case nostr.IsAddressableKind(evt.Kind):
+		address = fmt.Sprintf("%d:%s:%s", evt.Kind, evt.PubKey, evt.Tags.GetD())
+	default:
+		return
+	}
+
+	current := v.latest[address]
+	switch {
+	case current == nil:
+		v.latest[address] = evt
+	case current.ID == evt.ID:
+	case supersedes(evt, current):
+		v.stale = append(v.stale, current)
+		v.latest[address] = evt
+	default:
+		v.stale = append(v.stale, evt)
+	}
+}
+
+// supersedes applies NIP-01: the newer version wins, and on equal timestamps
+// the lowest ID.
+func supersedes(a, b *nostr.Event) bool {
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt > b.CreatedAt
+	}
+	return a.ID < b.ID
+}
+
+// runVerify checks every stored event's ID and signature, looks for stale
+// replaceable/addressable versions and, on Badger, for index entries that
+// disagree with the raw events. With --repair it removes invalid records,
+// rebuilds missing indexes and deletes stale versions. The relay must not be
+// running, since it holds the storage lock.
+// Usage: tenex-relay verify [--repair] [--skip-signatures] [--report report.json]
+func runVerify(config *Config, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "Fix the problems found")
+	skipSignatures := fs.Bool("skip-signatures", false, "Skip signature checks (IDs are still checked)")
+	reportPath := fs.String("report", "", "Write a JSON report to this path ('-' for stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if config.Storage.Backend == storageMemory {
+		return errors.New("the memory backend holds no data to verify")
+	}
+	if relayRunning(config) {
+		return errors.New("the relay is running; stop it before verifying")
+	}
+
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s storage: %w", config.Storage.Backend, err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	report := &verifyReport{
+		Backend: config.Storage.Backend,
+		Counts:  make(map[string]int),
+		Issues:  []verifyIssue{},
+	}
+	log.Printf("Verifying %s store at %s...", config.Storage.Backend, config.Storage.resolvedPath(config.DataDir))
+
+	ctx := context.Background()
+	if bdb, ok := db.(*evbadger.BadgerBackend); ok {
+		err = verifyBadger(ctx, bdb, *skipSignatures, *repair, report)
+	} else {
+		err = verifyStore(ctx, db, *skipSignatures, *repair, report)
+	}
+	if err != nil {
+		return err
+	}
+	report.Repaired = *repair && report.problems() > 0
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	if report.Repaired {
+		// Repairs add and remove events behind the search index's back.
+		if err := resetSearchIndex(config.DataDir); err != nil {
+			return err
+		}
+	}
+
+	if err := writeVerifyReport(report, *reportPath); err != nil {
+		return err
+	}
+
+	log.Printf("Verified %d events in %s", report.Events, report.Duration)
+	kinds := make([]string, 0, len(report.Counts))
+	for kind := range report.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		log.Printf("  %s: %d", kind, report.Counts[kind])
+	}
+
+	if n := report.problems(); n > 0 && !report.Repaired {
+		return fmt.Errorf("found %d problem(s); re-run with --repair to fix them", n)
+	}
+	if report.Repaired {
+		log.Printf("Repaired %d problem(s)", report.problems())
+	}
+	return nil
+}
+
+func writeVerifyReport(report *verifyReport, path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// verifyStore runs the backend-independent checks through the Store
+// interface.
+func verifyStore(ctx context.Context, db eventstore.Store, skipSignatures, repair bool, report *verifyReport) error {
+	versions := newVersionTracker()
+	var invalid []*nostr.Event
+
+	err := scanEvents(ctx, db, nostr.Filter{}, func(evt *nostr.Event) error {
+		report.Events++
+		if problem := checkEvent(evt, skipSignatures); problem != "" {
+			report.add(problem, evt.ID, "")
+			invalid = append(invalid, evt)
+			return nil
+		}
+		versions.add(evt)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("read error: %w", err)
+	}
+	for _, evt := range versions.stale {
+		report.add(verifyStaleVersion, evt.ID, fmt.Sprintf("kind %d", evt.Kind))
+	}
+
+	if !repair {
+		return nil
+	}
+	for _, evt := range append(invalid, versions.stale...) {
+		if err := db.DeleteEvent(ctx, evt); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", evt.ID, err)
+		}
+	}
+	return nil
+}
+
+// verifyBadger reads the raw Badger records directly, so it also finds
+// events that no index reaches and index entries whose event is gone.
+func verifyBadger(ctx context.Context, db *evbadger.BadgerBackend, skipSignatures, repair bool, report *verifyReport) error {
+	serials := make(map[uint32]bool)
+	versions := newVersionTracker()
+	var drop [][]byte // raw keys to remove
+	var reindex []*nostr.Event
+
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{badgerRawPrefix}, PrefetchValues: true})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.KeyCopy(nil)
+			if len(key) != badgerRawEventKeyLength {
+				continue
+			}
+			serials[binary.BigEndian.Uint32(key[1:])] = true
+			report.Events++
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			evt, err := decodeBadgerEvent(value)
+			if err != nil {
+				report.add(verifyDecodeError, "", fmt.Sprintf("raw record %x: %v", key, err))
+				drop = append(drop, key)
+				continue
+			}
+			if problem := checkEvent(evt, skipSignatures); problem != "" {
+				report.add(problem, evt.ID, "")
+				drop = append(drop, key)
+				continue
+			}
+			if !badgerHasKey(txn, badgerIDIndexKey(evt.ID, key[1:])) ||
+				!badgerHasKey(txn, badgerCreatedAtIndexKey(evt.CreatedAt, key[1:])) ||
+				!badgerHasKey(txn, badgerKindIndexKey(evt.Kind, evt.CreatedAt, key[1:])) {
+				report.add(verifyMissingIndex, evt.ID, "")
+				drop = append(drop, key)
+				reindex = append(reindex, evt)
+			}
+			versions.add(evt)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("read error: %w", err)
+	}
+
+	duplicates, err := badgerDuplicateRecords(db)
+	if err != nil {
+		return fmt.Errorf("read error: %w", err)
+	}
+	for _, dup := range duplicates {
+		report.add(verifyDuplicateRecord, dup.id, fmt.Sprintf("raw record %x", dup.key))
+		drop = append(drop, dup.key)
+	}
+
+	for _, evt := range versions.stale {
+		report.add(verifyStaleVersion, evt.ID, fmt.Sprintf("kind %d", evt.Kind))
+	}
+
+	dangling, err := badgerDanglingIndexes(db, serials)
+	if err != nil {
+		return fmt.Errorf("read error: %w", err)
+	}
+	for _, key := range dangling {
+		report.add(verifyDanglingIndex, "", fmt.Sprintf("index key %x", key))
+	}
+
+	if !repair {
+		return nil
+	}
+
+	// Dropping a record leaves its index entries behind too; sweep them
+	// together with the ones that were already dangling.
+	for _, key := range drop {
+		delete(serials, binary.BigEndian.Uint32(key[1:]))
+	}
+	sweep, err := badgerDanglingIndexes(db, serials)
+	if err != nil {
+		return fmt.Errorf("read error: %w", err)
+	}
+
+	wb := db.DB.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range append(drop, sweep...) {
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return fmt.Errorf("failed to remove records: %w", err)
+	}
+
+	for _, evt := range reindex {
+		if err := db.SaveEvent(ctx, evt); err != nil && !errors.Is(err, eventstore.ErrDupEvent) {
+			return fmt.Errorf("failed to reindex %s: %w", evt.ID, err)
+		}
+	}
+	for _, evt := range versions.stale {
+		if err := db.DeleteEvent(ctx, evt); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", evt.ID, err)
+		}
+	}
+	return nil
+}
+
+type badgerDuplicate struct {
+	id  string
+	key []byte
+}
+
+// badgerDuplicateRecords walks the ID index, where entries sort by ID
+// prefix, and reports any ID stored under more than one serial. Every copy
+// after the first is returned.
+func badgerDuplicateRecords(db *evbadger.BadgerBackend) ([]badgerDuplicate, error) {
+	var duplicates []badgerDuplicate
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{badgerIDPrefix}})
+		defer it.Close()
+
+		var prevPrefix []byte
+		var prevID string
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if len(key) != badgerIDIndexKeyLength {
+				continue
+			}
+			rawKey := append([]byte{badgerRawPrefix}, key[1+8:]...)
+			id := badgerEventID(txn, rawKey)
+
+			// Two IDs can share an 8-byte prefix, so compare the full ID.
+			if bytes.Equal(key[1:1+8], prevPrefix) && id != "" && id == prevID {
+				duplicates = append(duplicates, badgerDuplicate{id: id, key: rawKey})
+				continue
+			}
+			prevPrefix = key[1 : 1+8]
+			prevID = id
+		}
+		return nil
+	})
+	return duplicates, err
+}
+
+// badgerDanglingIndexes returns index keys whose serial has no raw record in
+// serials.
+func badgerDanglingIndexes(db *evbadger.BadgerBackend, serials map[uint32]bool) ([][]byte, error) {
+	var dangling [][]byte
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{})
+		defer it.Close()
+
+		for it.Seek([]byte{badgerCreatedAtPrefix}); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			if key[0] > badgerLastIndexPrefix {
+				break
+			}
+			if len(key) < 1+badgerSerialLength {
+				continue
+			}
+			if !serials[binary.BigEndian.Uint32(key[len(key)-badgerSerialLength:])] {
+				dangling = append(dangling, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	return dangling, err
+}
+
+func badgerEventID(txn *badger.Txn, rawKey []byte) string {
+	item, err := txn.Get(rawKey)
+	if err != nil {
+		return ""
+	}
+	var id string
+	item.Value(func(val []byte) error {
+		if len(val) >= 32 {
+			id = hex.EncodeToString(val[:32])
+		}
+		return nil
+	})
+	return id
+}
+
+func badgerHasKey(txn *badger.Txn, key []byte) bool {
+	_, err := txn.Get(key)
+	return err == nil
+}
+
+func badgerIDIndexKey(id string, serial []byte) []byte {
+	prefix, _ := hex.DecodeString(id[:16])
+	key := make([]byte, 0, badgerIDIndexKeyLength)
+	key = append(key, badgerIDPrefix)
+	key = append(key, prefix...)
+	return append(key, serial...)
+}
+
+func badgerCreatedAtIndexKey(createdAt nostr.Timestamp, serial []byte) []byte {
+	key := make([]byte, 1+4, 1+4+badgerSerialLength)
+	key[0] = badgerCreatedAtPrefix
+	binary.BigEndian.PutUint32(key[1:], uint32(createdAt))
+	return append(key, serial...)
+}
+
+func badgerKindIndexKey(kind int, createdAt nostr.Timestamp, serial []byte) []byte {
+	key := make([]byte, 1+2+4, badgerKindKeyLength)
+	key[0] = badgerKindPrefix
+	binary.BigEndian.PutUint16(key[1:], uint16(kind))
+	binary.BigEndian.PutUint32(key[1+2:], uint32(createdAt))
+	return append(key, serial...)
+}
+
+// decodeBadgerEvent decodes a raw record in the binary layout the eventstore
+// badger backend writes; its own decoder is internal to that module.
+func decodeBadgerEvent(data []byte) (evt *nostr.Event, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			evt, err = nil, fmt.Errorf("truncated record: %v", r)
+		}
+	}()
+
+	evt = &nostr.Event{
+		ID:        hex.EncodeToString(data[0:32]),
+		PubKey:    hex.EncodeToString(data[32:64]),
+		Sig:       hex.EncodeToString(data[64:128]),
+		CreatedAt: nostr.Timestamp(binary.BigEndian.Uint32(data[128:132])),
+		Kind:      int(binary.BigEndian.Uint16(data[132:134])),
+	}
+	contentLength := int(binary.BigEndian.Uint16(data[134:136]))
+	evt.Content = string(data[136 : 136+contentLength])
+
+	curr := 136 + contentLength
+	nTags := binary.BigEndian.Uint16(data[curr : curr+2])
+	curr++
+	evt.Tags = make(nostr.Tags, nTags)
+	for t := range evt.Tags {
+		curr++
+		nItems := int(data[curr])
+		tag := make(nostr.Tag, nItems)
+		for i := range tag {
+			curr++
+			itemSize := int(binary.BigEndian.Uint16(data[curr : curr+2]))
+			itemStart := curr + 2
+			tag[i] = string(data[itemStart : itemStart+itemSize])
+			curr = itemStart + itemSize
+		}
+		evt.Tags[t] = tag
+	}
+	return evt, nil
+}
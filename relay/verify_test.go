@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+	evbadger "github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestVerifyFindsAndRepairsBadgerProblems(t *testing.T) {
+	ctx := context.Background()
+	sk := nostr.GeneratePrivateKey()
+
+	config := DefaultConfig()
+	config.DataDir = t.TempDir()
+	config.Port = 1 // nothing listens here, so the relay isn't "running"
+
+	db, err := openStore(config.Storage, config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	bdb := db.(*evbadger.BadgerBackend)
+
+	good := signedEvent(t, sk, "good")
+	unindexed := signedEvent(t, sk, "unindexed")
+	forged := signedEvent(t, sk, "forged")
+	forged.Sig = good.Sig
+	profileOld := &nostr.Event{CreatedAt: 1700000000, Kind: 0, Tags: nostr.Tags{}, Content: "old"}
+	profileNew := &nostr.Event{CreatedAt: 1700000100, Kind: 0, Tags: nostr.Tags{}, Content: "new"}
+	for _, evt := range []*nostr.Event{profileOld, profileNew} {
+		if err := evt.Sign(sk); err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+	}
+
+	// SaveEvent (not ReplaceEvent) keeps both profile versions, as an
+	// interrupted replace could.
+	for _, evt := range []*nostr.Event{good, unindexed, forged, profileOld, profileNew} {
+		if err := bdb.SaveEvent(ctx, evt); err != nil {
+			t.Fatalf("failed to save event: %v", err)
+		}
+	}
+
+	// Drop the created_at index of one event and the kind index of another,
+	// and add an index entry that points at no record.
+	err = bdb.DB.Update(func(txn *badger.Txn) error {
+		serials := map[string][]byte{}
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{badgerIDPrefix}})
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			serials[badgerEventID(txn, append([]byte{badgerRawPrefix}, key[1+8:]...))] = key[1+8:]
+		}
+		it.Close()
+		if err := txn.Delete(badgerCreatedAtIndexKey(unindexed.CreatedAt, serials[unindexed.ID])); err != nil {
+			return err
+		}
+		if err := txn.Delete(badgerKindIndexKey(good.Kind, good.CreatedAt, serials[good.ID])); err != nil {
+			return err
+		}
+		orphan := make([]byte, 4)
+		binary.BigEndian.PutUint32(orphan, 999999)
+		return txn.Set(badgerCreatedAtIndexKey(nostr.Now(), orphan), nil)
+	})
+	if err != nil {
+		t.Fatalf("failed to damage store: %v", err)
+	}
+	db.Close()
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := runVerify(config, []string{"--report", reportPath}); err == nil {
+		t.Fatal("expected verify to fail on a damaged store")
+	}
+	report := readVerifyReport(t, reportPath)
+	for kind, want := range map[string]int{
+		verifyInvalidSignature: 1,
+		verifyMissingIndex:     2,
+		verifyDanglingIndex:    1,
+		verifyStaleVersion:     1,
+	} {
+		if got := report.Counts[kind]; got != want {
+			t.Errorf("expected %d %s, got %d", want, kind, got)
+		}
+	}
+	if report.Events != 5 {
+		t.Fatalf("expected 5 events scanned, got %d", report.Events)
+	}
+
+	if err := runVerify(config, []string{"--repair"}); err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if err := runVerify(config, []string{"--report", reportPath}); err != nil {
+		t.Fatalf("expected a clean store after repair: %v", err)
+	}
+
+	db, err = openStore(config.Storage, config.DataDir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer db.Close()
+
+	// The reindexed events are reachable through their indexes again.
+	stored := map[string]bool{}
+	err = scanEvents(ctx, db, nostr.Filter{}, func(evt *nostr.Event) error {
+		stored[evt.ID] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if len(stored) != 3 || !stored[good.ID] || !stored[unindexed.ID] || !stored[profileNew.ID] {
+		t.Fatalf("expected good, reindexed and newest profile events to remain, got %v", stored)
+	}
+
+	// The event that lost its kind index is found by kind again.
+	byKind, err := db.QueryEvents(ctx, nostr.Filter{Kinds: []int{good.Kind}})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	found := false
+	for evt := range byKind {
+		found = found || evt.ID == good.ID
+	}
+	if !found {
+		t.Fatal("expected the event to be found by kind after repair")
+	}
+}
+
+func readVerifyReport(t *testing.T, path string) verifyReport {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var report verifyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	return report
+}